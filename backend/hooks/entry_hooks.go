@@ -4,6 +4,8 @@ import (
 	"log"
 	"time"
 
+	"ai-journal-backend/migrations"
+
 	"github.com/pocketbase/pocketbase/core"
 )
 
@@ -35,20 +37,54 @@ func RegisterEntryHooks(app core.App) {
 	app.OnRecordAfterUpdateSuccess("journal_entries").BindFunc(func(e *core.RecordEvent) error {
 		record := e.Record
 
-		// Invalidate heatmap cache when entry is modified
+		// 1. Apply word count and date changes to user stats
+		if err := updateUserStatsAfterEdit(app, record); err != nil {
+			log.Printf("Warning: Failed to update user stats after edit: %v", err)
+		}
+
+		// 2. Invalidate heatmap cache when entry is modified, including the
+		// month it was moved out of
 		if err := invalidateHeatmapCache(app, record); err != nil {
 			log.Printf("Warning: Failed to invalidate heatmap cache: %v", err)
 		}
+		if entryMonthChanged(app, record) {
+			if err := invalidateHeatmapCache(app, record.Original()); err != nil {
+				log.Printf("Warning: Failed to invalidate heatmap cache: %v", err)
+			}
+		}
 
-		// Re-queue AI analysis if content changed significantly
-		// TODO: Add logic to detect if encrypted_content changed
-		if err := queueAIAnalysisJob(app, record); err != nil {
-			log.Printf("Warning: Failed to queue AI job: %v", err)
+		// Re-queue AI analysis only if the content changed (not for mood/tags edits)
+		if entryContentChanged(record) {
+			if err := queueAIAnalysisJob(app, record); err != nil {
+				log.Printf("Warning: Failed to queue AI job: %v", err)
+			}
 		}
 
 		return e.Next()
 	})
 
+	// Hook: Journal entry delete
+	app.OnRecordDelete("journal_entries").BindFunc(func(e *core.RecordEvent) error {
+		// Collect the AI work on the entry before the delete clears the relations
+		refs, err := migrations.FindEntryAIReferences(e.App, e.Record.Id)
+		if err != nil {
+			log.Printf("Warning: Failed to find AI jobs of entry %s: %v", e.Record.Id, err)
+		}
+
+		if err := e.Next(); err != nil {
+			return err
+		}
+
+		// Cancel its queued jobs and clean up analyses left without entries
+		if refs != nil {
+			if err := refs.Release(e.App); err != nil {
+				log.Printf("Warning: Failed to clean up AI jobs of entry %s: %v", e.Record.Id, err)
+			}
+		}
+
+		return nil
+	})
+
 	// Hook: After journal entry is deleted
 	app.OnRecordAfterDeleteSuccess("journal_entries").BindFunc(func(e *core.RecordEvent) error {
 		record := e.Record
@@ -94,14 +130,12 @@ func updateUserStatsAfterEntry(app core.App, record *core.Record) error {
 	totalWords := user.GetInt("total_words")
 	user.Set("total_words", totalWords+wordCount)
 
-	// Calculate and update streak
-	if err := calculateAndUpdateStreak(app, user, entryDate); err != nil {
+	// Calculate and update streak (and last entry date) on the user's local calendar
+	localDate := migrations.LocalDate(entryDate, migrations.UserLocation(user))
+	if err := calculateAndUpdateStreak(app, user, localDate); err != nil {
 		log.Printf("Warning: Failed to calculate streak: %v", err)
 	}
 
-	// Update last entry date
-	user.Set("last_entry_date", entryDate.Format("2006-01-02"))
-
 	if err := app.Save(user); err != nil {
 		return err
 	}
@@ -144,104 +178,74 @@ func updateUserStatsAfterDeletion(app core.App, record *core.Record) error {
 	return nil
 }
 
-// calculateAndUpdateStreak updates the writing streak based on the new entry
-func calculateAndUpdateStreak(app core.App, user *core.Record, newEntryDate time.Time) error {
-	lastEntryDateStr := user.GetString("last_entry_date")
-	var lastEntryDate time.Time
-	var err error
-
-	if lastEntryDateStr != "" {
-		lastEntryDate, err = time.Parse("2006-01-02", lastEntryDateStr)
-		if err != nil {
-			// If parsing fails, treat as no previous entry
-			lastEntryDate = time.Time{}
-		}
+// updateUserStatsAfterEdit applies the word count difference of an edited
+// entry to the user's stats and recomputes the streak when it moved to
+// another date
+func updateUserStatsAfterEdit(app core.App, record *core.Record) error {
+	wordDelta := record.GetInt("word_count") - record.Original().GetInt("word_count")
+	moved := entryDateChanged(record)
+	userID := record.GetString("user")
+	if userID == "" || (wordDelta == 0 && !moved) {
+		return nil
 	}
 
-	currentStreak := user.GetInt("current_streak")
-	longestStreak := user.GetInt("longest_streak")
+	user, err := app.FindRecordById("users", userID)
+	if err != nil {
+		return err
+	}
 
-	// Check if the new entry is consecutive day
-	if !lastEntryDate.IsZero() {
-		daysDiff := int(newEntryDate.Sub(lastEntryDate).Hours() / 24)
+	if wordDelta != 0 {
+		user.Set("total_words", max(0, int64(user.GetInt("total_words"))+int64(wordDelta)))
+	}
 
-		if daysDiff == 1 {
-			// Consecutive day - increment streak
-			currentStreak++
-		} else if daysDiff > 1 {
-			// Streak broken - start new streak
-			currentStreak = 1
+	if moved {
+		if err := recalculateStreak(app, user); err != nil {
+			log.Printf("Warning: Failed to recalculate streak: %v", err)
 		}
-		// If daysDiff == 0, same day - don't change streak
-		// If daysDiff < 0, backdated entry - handle specially
-	} else {
-		// First entry
-		currentStreak = 1
 	}
 
-	// Update longest streak
-	if currentStreak > longestStreak {
-		longestStreak = currentStreak
+	if err := app.Save(user); err != nil {
+		return err
 	}
 
-	user.Set("current_streak", currentStreak)
-	user.Set("longest_streak", longestStreak)
-
+	log.Printf("✅ Updated stats for user %s after editing entry %s (words %+d)", user.Email(), record.Id, wordDelta)
 	return nil
 }
 
-// recalculateStreak recalculates the entire streak from all user entries
-func recalculateStreak(app core.App, user *core.Record) error {
-	userID := user.Id
-
-	// Get all entries ordered by date
-	entries, err := app.FindRecordsByFilter(
-		"journal_entries",
-		"user = {:userId}",
-		"entry_date",
-		50,
-		0,
-		map[string]any{"userId": userID},
-	)
+// calculateAndUpdateStreak updates the writing streak based on the new
+// entry's local calendar date
+func calculateAndUpdateStreak(app core.App, user *core.Record, newEntryDate time.Time) error {
+	counter := userStreakCounter(user, loadStreakFreezePolicy())
 
-	if err != nil || len(entries) == 0 {
-		user.Set("current_streak", 0)
-		user.Set("longest_streak", 0)
-		return nil
+	// Only a live streak is continued from the stored state. A backdated
+	// entry can join or fill a gap between earlier streaks, and a lapsed
+	// streak is stored as 0, so both are recounted from all entries.
+	if counter.run == 0 || newEntryDate.Before(counter.lastDate) {
+		return recalculateStreak(app, user)
 	}
 
-	currentStreak := 1
-	longestStreak := 1
-	lastDate := entries[0].GetDateTime("entry_date").Time()
+	counter.add(newEntryDate)
+	setUserStreak(user, counter.summary(newEntryDate))
 
-	for i := 1; i < len(entries); i++ {
-		entryDate := entries[i].GetDateTime("entry_date").Time()
-		daysDiff := int(lastDate.Sub(entryDate).Hours() / 24)
-
-		if daysDiff == 1 {
-			currentStreak++
-		} else if daysDiff > 1 {
-			if currentStreak > longestStreak {
-				longestStreak = currentStreak
-			}
-			currentStreak = 1
-		}
+	return nil
+}
 
-		lastDate = entryDate
-	}
+// entryContentChanged reports whether an update changed the entry content,
+// comparing content_hash (or the ciphertext when no hash is set)
+func entryContentChanged(record *core.Record) bool {
+	original := record.Original()
 
-	// Final check
-	if currentStreak > longestStreak {
-		longestStreak = currentStreak
+	oldHash := original.GetString("content_hash")
+	newHash := record.GetString("content_hash")
+	if oldHash != "" || newHash != "" {
+		return oldHash != newHash
 	}
 
-	user.Set("current_streak", currentStreak)
-	user.Set("longest_streak", longestStreak)
-
-	return nil
+	return original.GetString("encrypted_content") != record.GetString("encrypted_content")
 }
 
-// queueAIAnalysisJob adds an AI analysis job to the processing queue
+// queueAIAnalysisJob adds an AI analysis job to the processing queue. A
+// pending analysis of the same entry is replaced instead of duplicated.
 func queueAIAnalysisJob(app core.App, record *core.Record) error {
 	// Get collections
 	queueCollection, err := app.FindCollectionByNameOrId("ai_processing_queue")
@@ -261,13 +265,19 @@ func queueAIAnalysisJob(app core.App, record *core.Record) error {
 	job.Set("priority", 5) // Medium priority
 	job.Set("attempts", 0)
 	job.Set("scheduled_at", scheduledAt)
-	job.Set("estimated_tokens", 1000) // Estimate for single entry analysis
+	job.Set("estimated_tokens", migrations.EstimateEntryAnalysisTokens(record.GetInt("word_count")))
+	job.Set("dedupe_key", migrations.JobDedupeKey("entry_analysis", record.Id))
 
-	if err := app.Save(job); err != nil {
+	replaced, err := migrations.EnqueueUniqueJob(app, job)
+	if err != nil {
 		return err
 	}
 
-	log.Printf("✅ Queued AI analysis job for entry %s", record.Id)
+	if replaced {
+		log.Printf("♻️  Replaced pending AI analysis job for entry %s", record.Id)
+	} else {
+		log.Printf("✅ Queued AI analysis job for entry %s", record.Id)
+	}
 	return nil
 }
 
@@ -278,8 +288,9 @@ func invalidateHeatmapCache(app core.App, record *core.Record) error {
 		return nil
 	}
 
-	entryDate := record.GetDateTime("entry_date").Time()
-	if entryDate.IsZero() {
+	// The heatmap shows entries on the user's local calendar
+	entryDate, ok := migrations.EntryLocalDate(app, record)
+	if !ok {
 		return nil
 	}
 
@@ -0,0 +1,241 @@
+package hooks
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"ai-journal-backend/migrations"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Layout of the local calendar dates stored on users (last_entry_date)
+const streakDateLayout = "2006-01-02"
+
+// parseStreakDate parses a stored local calendar date (a date field holding
+// the local date at UTC midnight)
+func parseStreakDate(value string) (time.Time, bool) {
+	date, err := types.ParseDateTime(value)
+	if err != nil || date.IsZero() {
+		return time.Time{}, false
+	}
+
+	t := date.Time()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+}
+
+// daysBetween returns the number of calendar days from one local date to another
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// Number of distinct entry dates read per query when recalculating streaks
+const streakScanPageSize = 500
+
+// streakFreezePolicy sets how streak freezes are earned
+type streakFreezePolicy struct {
+	earnEvery int // streak days per freeze earned, 0 disables freezes
+	max       int // most freezes a user can hold
+}
+
+// loadStreakFreezePolicy reads STREAK_FREEZE_EARN_DAYS (default: 7) and
+// STREAK_FREEZE_MAX (default: 2)
+func loadStreakFreezePolicy() streakFreezePolicy {
+	policy := streakFreezePolicy{earnEvery: 7, max: 2}
+
+	if value, err := strconv.Atoi(os.Getenv("STREAK_FREEZE_EARN_DAYS")); err == nil && value >= 0 {
+		policy.earnEvery = value
+	}
+	if value, err := strconv.Atoi(os.Getenv("STREAK_FREEZE_MAX")); err == nil && value >= 0 {
+		policy.max = value
+	}
+
+	return policy
+}
+
+// streakSummary is the streak state derived from a user's entry dates
+type streakSummary struct {
+	current     int       // streak still alive today (see streakCounter.summary)
+	longest     int       // longest run of consecutive days
+	lastDate    time.Time // latest local entry date, zero without entries
+	freezes     int       // freezes held
+	freezesUsed int       // freezes spent on missed days
+}
+
+// streakCounter accumulates runs of consecutive local dates fed in ascending
+// order. A single missed day is bridged with a freeze when one is held, and a
+// freeze is earned every policy.earnEvery days of a run. Replaying all dates
+// gives the same result as counting them one by one as entries are written.
+type streakCounter struct {
+	policy      streakFreezePolicy
+	lastDate    time.Time
+	run         int
+	longest     int
+	freezes     int
+	freezesUsed int
+}
+
+// add counts a local date; repeats of the last date are ignored
+func (c *streakCounter) add(date time.Time) {
+	switch {
+	case c.run > 0 && date.Equal(c.lastDate):
+		return
+	case c.run > 0 && daysBetween(c.lastDate, date) == 1:
+		c.run++
+	case c.run > 0 && daysBetween(c.lastDate, date) == 2 && c.freezes > 0:
+		// Exactly one missed day: spend a freeze to keep the streak
+		c.freezes--
+		c.freezesUsed++
+		c.run++
+	default:
+		c.run = 1
+	}
+
+	if c.policy.earnEvery > 0 && c.run%c.policy.earnEvery == 0 && c.freezes < c.policy.max {
+		c.freezes++
+	}
+
+	if c.run > c.longest {
+		c.longest = c.run
+	}
+	c.lastDate = date
+}
+
+// summary returns the streaks as of the user's local date today. The last
+// run only counts as current while it ended today or yesterday, or the day
+// before when a freeze is held to bridge yesterday.
+func (c *streakCounter) summary(today time.Time) streakSummary {
+	summary := streakSummary{
+		longest:     c.longest,
+		lastDate:    c.lastDate,
+		freezes:     c.freezes,
+		freezesUsed: c.freezesUsed,
+	}
+	if c.run > 0 && streakAlive(c.lastDate, today, c.freezes) {
+		summary.current = c.run
+	}
+	return summary
+}
+
+// streakAlive reports whether a run ending on lastDate can still be continued today
+func streakAlive(lastDate, today time.Time, freezes int) bool {
+	days := daysBetween(lastDate, today)
+	return days <= 1 || (days == 2 && freezes > 0)
+}
+
+// summarizeStreaks computes the streaks from local dates sorted ascending
+func summarizeStreaks(dates []time.Time, today time.Time, policy streakFreezePolicy) streakSummary {
+	counter := &streakCounter{policy: policy}
+	for _, date := range dates {
+		counter.add(date)
+	}
+	return counter.summary(today)
+}
+
+// userStreakCounter resumes counting from the streak state stored on a user
+func userStreakCounter(user *core.Record, policy streakFreezePolicy) *streakCounter {
+	counter := &streakCounter{
+		policy:      policy,
+		run:         user.GetInt("current_streak"),
+		longest:     user.GetInt("longest_streak"),
+		freezes:     user.GetInt("streak_freezes"),
+		freezesUsed: user.GetInt("streak_freezes_used"),
+	}
+
+	if lastDate, ok := parseStreakDate(user.GetString("last_entry_date")); ok {
+		counter.lastDate = lastDate
+	} else {
+		counter.run = 0
+	}
+
+	return counter
+}
+
+// setUserStreak stores a streak summary on the user
+func setUserStreak(user *core.Record, summary streakSummary) {
+	user.Set("current_streak", summary.current)
+	user.Set("longest_streak", summary.longest)
+	user.Set("streak_freezes", summary.freezes)
+	user.Set("streak_freezes_used", summary.freezesUsed)
+	if summary.lastDate.IsZero() {
+		user.Set("last_entry_date", "")
+	} else {
+		user.Set("last_entry_date", summary.lastDate.Format(streakDateLayout))
+	}
+}
+
+// scanLocalEntryDates feeds every distinct entry date of a user to the
+// counter, oldest first, reading the dates in pages
+func scanLocalEntryDates(app core.App, userID string, loc *time.Location, counter *streakCounter) error {
+	after := ""
+
+	for {
+		var rawDates []string
+		err := app.DB().NewQuery(`
+			SELECT DISTINCT entry_date FROM journal_entries
+			WHERE user = {:userId} AND entry_date > {:after}
+			ORDER BY entry_date
+			LIMIT {:limit}
+		`).Bind(dbx.Params{
+			"userId": userID,
+			"after":  after,
+			"limit":  streakScanPageSize,
+		}).Column(&rawDates)
+		if err != nil {
+			return err
+		}
+
+		for _, raw := range rawDates {
+			entryDate, err := types.ParseDateTime(raw)
+			if err != nil || entryDate.IsZero() {
+				continue
+			}
+			counter.add(migrations.LocalDate(entryDate.Time(), loc))
+		}
+
+		if len(rawDates) < streakScanPageSize {
+			return nil
+		}
+		after = rawDates[len(rawDates)-1]
+	}
+}
+
+// recalculateStreak recalculates the streaks and last entry date from all of
+// the user's entries, on the user's local calendar
+func recalculateStreak(app core.App, user *core.Record) error {
+	loc := migrations.UserLocation(user)
+
+	counter := &streakCounter{policy: loadStreakFreezePolicy()}
+	if err := scanLocalEntryDates(app, user.Id, loc, counter); err != nil {
+		return err
+	}
+
+	setUserStreak(user, counter.summary(migrations.LocalDate(time.Now(), loc)))
+	return nil
+}
+
+// activeStreak returns the user's stored current streak, or 0 once it has
+// lapsed in the user's time zone
+func activeStreak(user *core.Record, now time.Time) int {
+	lastDate, ok := parseStreakDate(user.GetString("last_entry_date"))
+	if !ok || !streakAlive(lastDate, migrations.LocalDate(now, migrations.UserLocation(user)), user.GetInt("streak_freezes")) {
+		return 0
+	}
+	return user.GetInt("current_streak")
+}
+
+// entryDateChanged reports whether an update moved the entry to another date
+func entryDateChanged(record *core.Record) bool {
+	return !record.GetDateTime("entry_date").Equal(record.Original().GetDateTime("entry_date"))
+}
+
+// entryMonthChanged reports whether an update moved the entry to another
+// heatmap month of the user's local calendar
+func entryMonthChanged(app core.App, record *core.Record) bool {
+	oldDate, _ := migrations.EntryLocalDate(app, record.Original())
+	newDate, _ := migrations.EntryLocalDate(app, record)
+	return oldDate.Year() != newDate.Year() || oldDate.Month() != newDate.Month()
+}
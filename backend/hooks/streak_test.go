@@ -0,0 +1,436 @@
+package hooks
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"ai-journal-backend/migrations"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func date(value string) time.Time {
+	d, err := time.Parse(streakDateLayout, value)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func dates(values ...string) []time.Time {
+	result := make([]time.Time, 0, len(values))
+	for _, value := range values {
+		result = append(result, date(value))
+	}
+	return result
+}
+
+func TestSummarizeStreaks(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		dates    []time.Time
+		today    string
+		current  int
+		longest  int
+		lastDate string
+	}{
+		{
+			name:  "no entries",
+			today: "2026-03-10",
+		},
+		{
+			name:     "single entry today",
+			dates:    dates("2026-03-10"),
+			today:    "2026-03-10",
+			current:  1,
+			longest:  1,
+			lastDate: "2026-03-10",
+		},
+		{
+			name:     "run ending yesterday is still current",
+			dates:    dates("2026-03-07", "2026-03-08", "2026-03-09"),
+			today:    "2026-03-10",
+			current:  3,
+			longest:  3,
+			lastDate: "2026-03-09",
+		},
+		{
+			name:     "run ending two days ago has lapsed",
+			dates:    dates("2026-03-06", "2026-03-07", "2026-03-08"),
+			today:    "2026-03-10",
+			current:  0,
+			longest:  3,
+			lastDate: "2026-03-08",
+		},
+		{
+			name:     "longest run earlier than the current one",
+			dates:    dates("2026-02-01", "2026-02-02", "2026-02-03", "2026-02-04", "2026-03-09", "2026-03-10"),
+			today:    "2026-03-10",
+			current:  2,
+			longest:  4,
+			lastDate: "2026-03-10",
+		},
+		{
+			name:     "repeated dates count once",
+			dates:    dates("2026-03-08", "2026-03-08", "2026-03-09", "2026-03-09", "2026-03-10"),
+			today:    "2026-03-10",
+			current:  3,
+			longest:  3,
+			lastDate: "2026-03-10",
+		},
+		{
+			name:     "gap resets the run",
+			dates:    dates("2026-03-01", "2026-03-02", "2026-03-04", "2026-03-05", "2026-03-06"),
+			today:    "2026-03-06",
+			current:  3,
+			longest:  3,
+			lastDate: "2026-03-06",
+		},
+		{
+			name:     "run across a month and year boundary",
+			dates:    dates("2025-12-30", "2025-12-31", "2026-01-01", "2026-01-02"),
+			today:    "2026-01-03",
+			current:  4,
+			longest:  4,
+			lastDate: "2026-01-02",
+		},
+		{
+			name:     "run across a leap day",
+			dates:    dates("2028-02-28", "2028-02-29", "2028-03-01"),
+			today:    "2028-03-01",
+			current:  3,
+			longest:  3,
+			lastDate: "2028-03-01",
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			summary := summarizeStreaks(s.dates, date(s.today), streakFreezePolicy{})
+
+			if summary.current != s.current {
+				t.Errorf("Expected current streak %d, got %d", s.current, summary.current)
+			}
+			if summary.longest != s.longest {
+				t.Errorf("Expected longest streak %d, got %d", s.longest, summary.longest)
+			}
+
+			lastDate := ""
+			if !summary.lastDate.IsZero() {
+				lastDate = summary.lastDate.Format(streakDateLayout)
+			}
+			if lastDate != s.lastDate {
+				t.Errorf("Expected last date %q, got %q", s.lastDate, lastDate)
+			}
+		})
+	}
+}
+
+func TestSummarizeStreaksWithFreezes(t *testing.T) {
+	policy := streakFreezePolicy{earnEvery: 3, max: 2}
+
+	scenarios := []struct {
+		name        string
+		policy      streakFreezePolicy
+		dates       []time.Time
+		today       string
+		current     int
+		longest     int
+		freezes     int
+		freezesUsed int
+	}{
+		{
+			name:    "freeze earned at the milestone",
+			policy:  policy,
+			dates:   dates("2026-03-01", "2026-03-02", "2026-03-03"),
+			today:   "2026-03-03",
+			current: 3,
+			longest: 3,
+			freezes: 1,
+		},
+		{
+			name:    "no freeze before the milestone",
+			policy:  policy,
+			dates:   dates("2026-03-01", "2026-03-02", "2026-03-04"),
+			today:   "2026-03-04",
+			current: 1,
+			longest: 2,
+		},
+		{
+			name:        "one missed day is bridged",
+			policy:      policy,
+			dates:       dates("2026-03-01", "2026-03-02", "2026-03-03", "2026-03-05"),
+			today:       "2026-03-05",
+			current:     4,
+			longest:     4,
+			freezesUsed: 1,
+		},
+		{
+			name:    "two missed days break the streak",
+			policy:  policy,
+			dates:   dates("2026-03-01", "2026-03-02", "2026-03-03", "2026-03-06"),
+			today:   "2026-03-06",
+			current: 1,
+			longest: 3,
+			freezes: 1,
+		},
+		{
+			name:    "held freeze keeps the streak alive over yesterday",
+			policy:  policy,
+			dates:   dates("2026-03-01", "2026-03-02", "2026-03-03"),
+			today:   "2026-03-05",
+			current: 3,
+			longest: 3,
+			freezes: 1,
+		},
+		{
+			name:    "streak lapses without a freeze",
+			policy:  policy,
+			dates:   dates("2026-03-01", "2026-03-02"),
+			today:   "2026-03-04",
+			current: 0,
+			longest: 2,
+		},
+		{
+			name:    "freezes are capped",
+			policy:  policy,
+			dates:   dates("2026-03-01", "2026-03-02", "2026-03-03", "2026-03-04", "2026-03-05", "2026-03-06", "2026-03-07", "2026-03-08", "2026-03-09"),
+			today:   "2026-03-09",
+			current: 9,
+			longest: 9,
+			freezes: 2,
+		},
+		{
+			name:    "disabled policy never earns freezes",
+			policy:  streakFreezePolicy{},
+			dates:   dates("2026-03-01", "2026-03-02", "2026-03-03", "2026-03-05"),
+			today:   "2026-03-05",
+			current: 1,
+			longest: 3,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			summary := summarizeStreaks(s.dates, date(s.today), s.policy)
+
+			if summary.current != s.current {
+				t.Errorf("Expected current streak %d, got %d", s.current, summary.current)
+			}
+			if summary.longest != s.longest {
+				t.Errorf("Expected longest streak %d, got %d", s.longest, summary.longest)
+			}
+			if summary.freezes != s.freezes {
+				t.Errorf("Expected %d freezes, got %d", s.freezes, summary.freezes)
+			}
+			if summary.freezesUsed != s.freezesUsed {
+				t.Errorf("Expected %d freezes used, got %d", s.freezesUsed, summary.freezesUsed)
+			}
+		})
+	}
+}
+
+func TestStreakCounterResumesLikeReplay(t *testing.T) {
+	policy := streakFreezePolicy{earnEvery: 3, max: 2}
+	all := dates("2026-03-01", "2026-03-02", "2026-03-03", "2026-03-05", "2026-03-06", "2026-03-08")
+	today := date("2026-03-08")
+
+	// Count the first dates, then continue from the summary as if stored on the user
+	first := summarizeStreaks(all[:4], all[3], policy)
+	resumed := &streakCounter{
+		policy:      policy,
+		lastDate:    first.lastDate,
+		run:         first.current,
+		longest:     first.longest,
+		freezes:     first.freezes,
+		freezesUsed: first.freezesUsed,
+	}
+	for _, d := range all[4:] {
+		resumed.add(d)
+	}
+
+	expected := summarizeStreaks(all, today, policy)
+	if result := resumed.summary(today); result != expected {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+}
+
+func TestDaysBetweenAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		name     string
+		from     string
+		to       string
+		expected int
+	}{
+		// 23 hours apart on the wall clock
+		{"spring forward", "2026-03-08T04:00:00Z", "2026-03-09T03:30:00Z", 1},
+		// 25 hours apart on the wall clock
+		{"fall back", "2026-10-31T04:30:00Z", "2026-11-01T05:30:00Z", 1},
+		{"same local day", "2026-03-08T05:00:00Z", "2026-03-09T03:00:00Z", 0},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			from, _ := time.Parse(time.RFC3339, s.from)
+			to, _ := time.Parse(time.RFC3339, s.to)
+
+			result := daysBetween(migrations.LocalDate(from, newYork), migrations.LocalDate(to, newYork))
+			if result != s.expected {
+				t.Errorf("Expected %d days, got %d", s.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseStreakDate(t *testing.T) {
+	scenarios := []struct {
+		value    string
+		expected string
+		ok       bool
+	}{
+		{"", "", false},
+		{"invalid", "", false},
+		{"2026-03-10", "2026-03-10", true},
+		{"2026-03-10 00:00:00.000Z", "2026-03-10", true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.value, func(t *testing.T) {
+			result, ok := parseStreakDate(s.value)
+			if ok != s.ok {
+				t.Fatalf("Expected ok %v, got %v", s.ok, ok)
+			}
+			if ok && result.Format(streakDateLayout) != s.expected {
+				t.Errorf("Expected %s, got %s", s.expected, result.Format(streakDateLayout))
+			}
+		})
+	}
+}
+
+func TestScanLocalEntryDates(t *testing.T) {
+	app, err := tests.NewTestApp(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Cleanup()
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	scenarios := []struct {
+		name     string
+		days     int
+		times    []time.Duration // entry times on each day (UTC), repeats are exact duplicates
+		loc      *time.Location
+		longest  int
+		lastDate string
+	}{
+		{
+			name:     "single page",
+			days:     10,
+			times:    []time.Duration{9 * time.Hour},
+			loc:      time.UTC,
+			longest:  10,
+			lastDate: "2023-01-10",
+		},
+		{
+			name:     "exactly one page",
+			days:     streakScanPageSize,
+			times:    []time.Duration{9 * time.Hour},
+			loc:      time.UTC,
+			longest:  streakScanPageSize,
+			lastDate: "2024-05-14",
+		},
+		{
+			name:     "several pages",
+			days:     2*streakScanPageSize + 1,
+			times:    []time.Duration{9 * time.Hour},
+			loc:      time.UTC,
+			longest:  2*streakScanPageSize + 1,
+			lastDate: "2025-09-27",
+		},
+		{
+			name:     "duplicate entry dates are read once",
+			days:     streakScanPageSize + 100,
+			times:    []time.Duration{9 * time.Hour, 9 * time.Hour},
+			loc:      time.UTC,
+			longest:  streakScanPageSize + 100,
+			lastDate: "2024-08-22",
+		},
+		{
+			name:     "several entries a day across the page boundary",
+			days:     300,
+			times:    []time.Duration{6 * time.Hour, 12 * time.Hour, 18 * time.Hour},
+			loc:      time.UTC,
+			longest:  300,
+			lastDate: "2023-10-27",
+		},
+		{
+			name:     "dates in the user's time zone",
+			days:     3,
+			times:    []time.Duration{20 * time.Hour}, // 05:00 the next day in Tokyo
+			loc:      tokyo,
+			longest:  3,
+			lastDate: "2023-01-04",
+		},
+	}
+
+	entries, err := app.FindCollectionByNameOrId("journal_entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			user := core.NewRecord(users)
+			user.SetEmail(fmt.Sprintf("scan%d@example.com", i))
+			user.SetPassword("1234567890")
+			if err := app.Save(user); err != nil {
+				t.Fatal(err)
+			}
+
+			for day := 0; day < s.days; day++ {
+				for _, at := range s.times {
+					entry := core.NewRecord(entries)
+					entry.Set("user", user.Id)
+					entry.Set("entry_date", first.AddDate(0, 0, day).Add(at))
+					entry.Set("encrypted_content", "iv:content")
+					if err := app.Save(entry); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			counter := &streakCounter{}
+			if err := scanLocalEntryDates(app, user.Id, s.loc, counter); err != nil {
+				t.Fatal(err)
+			}
+
+			summary := counter.summary(date(s.lastDate))
+			if summary.longest != s.longest {
+				t.Errorf("Expected longest streak %d, got %d", s.longest, summary.longest)
+			}
+			if summary.current != s.longest {
+				t.Errorf("Expected current streak %d, got %d", s.longest, summary.current)
+			}
+			if lastDate := summary.lastDate.Format(streakDateLayout); lastDate != s.lastDate {
+				t.Errorf("Expected last date %s, got %s", s.lastDate, lastDate)
+			}
+		})
+	}
+}
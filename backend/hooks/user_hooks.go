@@ -2,6 +2,9 @@ package hooks
 
 import (
 	"log"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 
 	"github.com/pocketbase/pocketbase/core"
 )
@@ -34,14 +37,77 @@ func RegisterUserHooks(app core.App) {
 
 	// Hook: Before user is updated
 	app.OnRecordUpdate("users").BindFunc(func(e *core.RecordEvent) error {
-		// Prevent direct manipulation of stats (should only be updated via hooks)
-		// This is a basic security measure - more validation can be added
-		_ = e.Record // Suppress unused variable warning - placeholder for future validation
+		// Streaks are counted on local calendar dates, so a new time zone
+		// can join or split days
+		if e.Record.GetString("timezone") != e.Record.Original().GetString("timezone") {
+			if err := recalculateStreak(e.App, e.Record); err != nil {
+				log.Printf("Warning: Failed to recalculate streak: %v", err)
+			}
+		}
+
+		return e.Next()
+	})
+
+	// Hook: Validate user fields before every save
+	app.OnRecordValidate("users").BindFunc(func(e *core.RecordEvent) error {
+		if tz := e.Record.GetString("timezone"); tz != "" {
+			if _, err := time.LoadLocation(tz); err != nil {
+				return validation.Errors{
+					"timezone": validation.NewError("validation_invalid_timezone", "Must be a valid IANA time zone name."),
+				}
+			}
+		}
+
+		return e.Next()
+	})
+
+	// Hook: Before user is updated through the API
+	app.OnRecordUpdateRequest("users").BindFunc(func(e *core.RecordRequestEvent) error {
+		// The analysis key and its audit trail are managed by /api/ai/analysis-key
+		// only, AI usage and quotas by the queue processor and superusers,
+		// streak freezes by the streak engine
+		if !e.HasSuperuserAuth() {
+			original := e.Record.Original()
+			for _, field := range protectedAnalysisKeyFields {
+				e.Record.Set(field, original.Get(field))
+			}
+			for _, field := range protectedAIUsageFields {
+				e.Record.Set(field, original.Get(field))
+			}
+			for _, field := range protectedStreakFreezeFields {
+				e.Record.Set(field, original.Get(field))
+			}
+		}
 
 		return e.Next()
 	})
 }
 
+// protectedAnalysisKeyFields can't be changed through the regular users API
+var protectedAnalysisKeyFields = []string{
+	"server_analysis_enabled",
+	"analysis_key_wrapped",
+	"analysis_key_expires_at",
+	"analysis_key_last_used_at",
+	"analysis_key_audit",
+}
+
+// protectedAIUsageFields can't be changed through the regular users API
+var protectedAIUsageFields = []string{
+	"ai_tokens_today",
+	"ai_usage_day",
+	"ai_tokens_month",
+	"ai_usage_month",
+	"ai_daily_token_quota",
+	"ai_monthly_token_quota",
+}
+
+// protectedStreakFreezeFields are earned and spent by the streak engine only
+var protectedStreakFreezeFields = []string{
+	"streak_freezes",
+	"streak_freezes_used",
+}
+
 // GetUserStats retrieves formatted statistics for a user
 func GetUserStats(app core.App, userID string) (map[string]interface{}, error) {
 	user, err := app.FindRecordById("users", userID)
@@ -50,12 +116,15 @@ func GetUserStats(app core.App, userID string) (map[string]interface{}, error) {
 	}
 
 	stats := map[string]interface{}{
-		"total_entries":      user.GetInt("total_entries"),
-		"total_words":        user.GetInt("total_words"),
-		"current_streak":     user.GetInt("current_streak"),
-		"longest_streak":     user.GetInt("longest_streak"),
-		"last_entry_date":    user.GetString("last_entry_date"),
-		"analysis_frequency": user.GetString("preferred_analysis_frequency"),
+		"total_entries":       user.GetInt("total_entries"),
+		"total_words":         user.GetInt("total_words"),
+		"current_streak":      activeStreak(user, time.Now()),
+		"longest_streak":      user.GetInt("longest_streak"),
+		"last_entry_date":     user.GetString("last_entry_date"),
+		"analysis_frequency":  user.GetString("preferred_analysis_frequency"),
+		"streak_freezes":      user.GetInt("streak_freezes"),
+		"streak_freezes_max":  loadStreakFreezePolicy().max,
+		"streak_freezes_used": user.GetInt("streak_freezes_used"),
 	}
 
 	return stats, nil
@@ -47,6 +47,15 @@ func main() {
 	hooks.RegisterUserHooks(app)
 	log.Println("✅ Hooks registered successfully!")
 
+	// Register custom API routes
+	migrations.RegisterAnalysisKeyRoutes(app)
+	migrations.RegisterClientAnalysisRoutes(app)
+	migrations.RegisterDeadLetterRoutes(app)
+	migrations.RegisterJobStatusRoutes(app)
+
+	// Register custom CLI commands
+	app.RootCmd.AddCommand(migrations.NewReplayFailedJobsCommand(app))
+
 	// Run seeders and start background services after app starts
 	app.OnServe().BindFunc(func(e *core.ServeEvent) error {
 		// Run seeders if enabled
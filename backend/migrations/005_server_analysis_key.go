@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// Users Collection - Opt-in server-side decryption for AI analysis
+		// ================================================================
+		users, err := app.FindCollectionByNameOrId("_pb_users_auth_")
+		if err != nil {
+			return err
+		}
+
+		// Explicit opt-in to let the queue decrypt entries for AI analysis
+		users.Fields.Add(&core.BoolField{
+			Name: "server_analysis_enabled",
+		})
+
+		// Analysis key wrapped with the server's public key (never returned by the API)
+		users.Fields.Add(&core.TextField{
+			Name:   "analysis_key_wrapped",
+			Hidden: true,
+		})
+
+		// When the wrapped analysis key stops being usable
+		users.Fields.Add(&core.DateField{
+			Name: "analysis_key_expires_at",
+		})
+
+		// Last time the queue unwrapped the analysis key
+		users.Fields.Add(&core.DateField{
+			Name: "analysis_key_last_used_at",
+		})
+
+		// Audit trail of every grant, use and revocation of the analysis key
+		// Structure: [{"at": "...", "action": "used", "job_id": "...", "job_type": "..."}, ...]
+		users.Fields.Add(&core.JSONField{
+			Name: "analysis_key_audit",
+		})
+
+		return app.Save(users)
+	}, func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("_pb_users_auth_")
+		if err != nil {
+			return err
+		}
+
+		users.Fields.RemoveByName("server_analysis_enabled")
+		users.Fields.RemoveByName("analysis_key_wrapped")
+		users.Fields.RemoveByName("analysis_key_expires_at")
+		users.Fields.RemoveByName("analysis_key_last_used_at")
+		users.Fields.RemoveByName("analysis_key_audit")
+
+		return app.Save(users)
+	})
+}
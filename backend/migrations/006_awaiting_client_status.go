@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"slices"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Processing Queue - Jobs waiting for client-delegated analysis
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		status, ok := aiQueue.Fields.GetByName("status").(*core.SelectField)
+		if !ok {
+			return nil
+		}
+
+		if !slices.Contains(status.Values, "awaiting_client") {
+			status.Values = append(status.Values, "awaiting_client")
+		}
+
+		return app.Save(aiQueue)
+	}, func(app core.App) error {
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		// Put waiting jobs back into the regular queue before dropping the status
+		if _, err := app.DB().NewQuery("UPDATE ai_processing_queue SET status = 'pending' WHERE status = 'awaiting_client'").Execute(); err != nil {
+			return err
+		}
+
+		status, ok := aiQueue.Fields.GetByName("status").(*core.SelectField)
+		if !ok {
+			return nil
+		}
+
+		status.Values = slices.DeleteFunc(status.Values, func(v string) bool {
+			return v == "awaiting_client"
+		})
+
+		return app.Save(aiQueue)
+	})
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Processing Queue - Period covered by rollup jobs
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		// Start of the period summarized by weekly/monthly jobs
+		aiQueue.Fields.Add(&core.DateField{
+			Name: "period_start",
+		})
+
+		// End of the period summarized by weekly/monthly jobs
+		aiQueue.Fields.Add(&core.DateField{
+			Name: "period_end",
+		})
+
+		// Lookup index for scheduled rollups (one job per user, type and period)
+		aiQueue.AddIndex("idx_queue_user_type_period", false, "user,job_type,period_start", "")
+
+		return app.Save(aiQueue)
+	}, func(app core.App) error {
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		aiQueue.RemoveIndex("idx_queue_user_type_period")
+		aiQueue.Fields.RemoveByName("period_start")
+		aiQueue.Fields.RemoveByName("period_end")
+
+		return app.Save(aiQueue)
+	})
+}
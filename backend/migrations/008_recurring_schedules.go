@@ -0,0 +1,58 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// 1. Users Collection - Time zone for local schedules
+		// ================================================================
+		users, err := app.FindCollectionByNameOrId("_pb_users_auth_")
+		if err != nil {
+			return err
+		}
+
+		// IANA time zone name (e.g. "Asia/Tokyo"), empty = AI_DEFAULT_TIMEZONE
+		users.Fields.Add(&core.TextField{
+			Name: "timezone",
+			Max:  64,
+		})
+
+		if err := app.Save(users); err != nil {
+			return err
+		}
+
+		// ================================================================
+		// 2. AI Processing Queue - At most one recurring job per period
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		aiQueue.RemoveIndex("idx_queue_user_type_period")
+		aiQueue.AddIndex("idx_queue_user_type_period", true, "user,job_type,period_start", "period_start != ''")
+
+		return app.Save(aiQueue)
+	}, func(app core.App) error {
+		if aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue"); err == nil {
+			aiQueue.RemoveIndex("idx_queue_user_type_period")
+			aiQueue.AddIndex("idx_queue_user_type_period", false, "user,job_type,period_start", "")
+			if err := app.Save(aiQueue); err != nil {
+				return err
+			}
+		}
+
+		users, err := app.FindCollectionByNameOrId("_pb_users_auth_")
+		if err != nil {
+			return err
+		}
+
+		users.Fields.RemoveByName("timezone")
+
+		return app.Save(users)
+	})
+}
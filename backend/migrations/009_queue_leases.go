@@ -0,0 +1,55 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Processing Queue - Leases for atomic job claiming
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		// Processor instance currently holding the job
+		aiQueue.Fields.Add(&core.TextField{
+			Name: "locked_by",
+		})
+
+		// When the current lease runs out (the job may then be reclaimed)
+		aiQueue.Fields.Add(&core.DateField{
+			Name: "lease_expires_at",
+		})
+
+		// Last heartbeat from the processor holding the lease
+		aiQueue.Fields.Add(&core.DateField{
+			Name: "heartbeat_at",
+		})
+
+		// Fresh jobs start at 0 attempts, which a required number field rejects
+		if attempts, ok := aiQueue.Fields.GetByName("attempts").(*core.NumberField); ok {
+			attempts.Required = false
+		}
+
+		// Lookup index for reclaiming expired leases
+		aiQueue.AddIndex("idx_queue_status_lease", false, "status,lease_expires_at", "")
+
+		return app.Save(aiQueue)
+	}, func(app core.App) error {
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		aiQueue.RemoveIndex("idx_queue_status_lease")
+		aiQueue.Fields.RemoveByName("locked_by")
+		aiQueue.Fields.RemoveByName("lease_expires_at")
+		aiQueue.Fields.RemoveByName("heartbeat_at")
+
+		return app.Save(aiQueue)
+	})
+}
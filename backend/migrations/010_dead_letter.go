@@ -0,0 +1,71 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Processing Queue - Per-attempt error history
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		// One {attempt, error, at} item per failed attempt
+		aiQueue.Fields.Add(&core.JSONField{
+			Name:    "error_history",
+			MaxSize: 65536,
+		})
+
+		if err := app.Save(aiQueue); err != nil {
+			return err
+		}
+
+		// ================================================================
+		// AI Dead Letter Queue - Failed jobs (superuser only)
+		// ================================================================
+		deadLetter := core.NewViewCollection("ai_dead_letter_queue")
+
+		// Access rules: superusers only (nil rules)
+		deadLetter.ListRule = nil
+		deadLetter.ViewRule = nil
+
+		deadLetter.ViewQuery = `
+			SELECT
+				id,
+				user,
+				job_type,
+				entry_id,
+				period_start,
+				period_end,
+				priority,
+				attempts,
+				error_message,
+				error_history,
+				completed_at AS failed_at
+			FROM ai_processing_queue
+			WHERE status = 'failed'
+		`
+
+		return app.Save(deadLetter)
+	}, func(app core.App) error {
+		if deadLetter, err := app.FindCollectionByNameOrId("ai_dead_letter_queue"); err == nil {
+			if err := app.Delete(deadLetter); err != nil {
+				return err
+			}
+		}
+
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		aiQueue.Fields.RemoveByName("error_history")
+
+		return app.Save(aiQueue)
+	})
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Processing Queue - Token usage reported by the provider
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		// Prompt tokens used by the last attempt
+		aiQueue.Fields.Add(&core.NumberField{
+			Name:    "prompt_tokens",
+			OnlyInt: true,
+		})
+
+		// Completion tokens used by the last attempt
+		aiQueue.Fields.Add(&core.NumberField{
+			Name:    "completion_tokens",
+			OnlyInt: true,
+		})
+
+		return app.Save(aiQueue)
+	}, func(app core.App) error {
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		aiQueue.Fields.RemoveByName("prompt_tokens")
+		aiQueue.Fields.RemoveByName("completion_tokens")
+
+		return app.Save(aiQueue)
+	})
+}
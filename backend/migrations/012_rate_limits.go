@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Rate Limits - Token bucket state shared by all processors
+		// ================================================================
+		rateLimits := core.NewBaseCollection("ai_rate_limits")
+
+		// Access rules: Backend only (no user access)
+		rateLimits.ListRule = nil
+		rateLimits.ViewRule = nil
+		rateLimits.CreateRule = nil
+		rateLimits.UpdateRule = nil
+		rateLimits.DeleteRule = nil
+
+		// Bucket name (one per provider key)
+		rateLimits.Fields.Add(&core.TextField{
+			Name:     "name",
+			Required: true,
+			Max:      64,
+		})
+
+		// Current balance (may be negative after a correction)
+		rateLimits.Fields.Add(&core.NumberField{
+			Name: "tokens",
+		})
+
+		// Unix time (seconds, fractional) of the last refill
+		rateLimits.Fields.Add(&core.NumberField{
+			Name: "updated_unix",
+		})
+
+		rateLimits.AddIndex("idx_rate_limits_name", true, "name", "")
+
+		return app.Save(rateLimits)
+	}, func(app core.App) error {
+		rateLimits, err := app.FindCollectionByNameOrId("ai_rate_limits")
+		if err != nil {
+			return nil
+		}
+		return app.Delete(rateLimits)
+	})
+}
@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// Users - AI token usage and quotas
+		// ================================================================
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Tokens used on ai_usage_day (user's local date, YYYY-MM-DD)
+		users.Fields.Add(&core.NumberField{
+			Name:    "ai_tokens_today",
+			OnlyInt: true,
+		})
+		users.Fields.Add(&core.TextField{
+			Name: "ai_usage_day",
+			Max:  10,
+		})
+
+		// Tokens used in ai_usage_month (user's local month, YYYY-MM)
+		users.Fields.Add(&core.NumberField{
+			Name:    "ai_tokens_month",
+			OnlyInt: true,
+		})
+		users.Fields.Add(&core.TextField{
+			Name: "ai_usage_month",
+			Max:  7,
+		})
+
+		// Per-user budgets overriding AI_USER_DAILY_TOKENS and
+		// AI_USER_MONTHLY_TOKENS (0 = use the default)
+		users.Fields.Add(&core.NumberField{
+			Name:    "ai_daily_token_quota",
+			OnlyInt: true,
+		})
+		users.Fields.Add(&core.NumberField{
+			Name:    "ai_monthly_token_quota",
+			OnlyInt: true,
+		})
+
+		return app.Save(users)
+	}, func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return nil
+		}
+
+		users.Fields.RemoveByName("ai_tokens_today")
+		users.Fields.RemoveByName("ai_usage_day")
+		users.Fields.RemoveByName("ai_tokens_month")
+		users.Fields.RemoveByName("ai_usage_month")
+		users.Fields.RemoveByName("ai_daily_token_quota")
+		users.Fields.RemoveByName("ai_monthly_token_quota")
+
+		return app.Save(users)
+	})
+}
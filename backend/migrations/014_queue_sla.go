@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Processing Queue - Max wait SLA warnings
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		// When the job was reported for waiting longer than its max wait
+		aiQueue.Fields.Add(&core.DateField{
+			Name: "sla_warned_at",
+		})
+
+		return app.Save(aiQueue)
+	}, func(app core.App) error {
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		aiQueue.Fields.RemoveByName("sla_warned_at")
+
+		return app.Save(aiQueue)
+	})
+}
@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Processing Queue - Deduplication of pending jobs
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		// Job type + target (e.g. "entry_analysis:<entry id>")
+		aiQueue.Fields.Add(&core.TextField{
+			Name: "dedupe_key",
+			Max:  128,
+		})
+
+		// At most one pending job per key; a new request replaces it
+		aiQueue.AddIndex("idx_queue_dedupe_pending", true, "dedupe_key", "status = 'pending' AND dedupe_key != ''")
+
+		return app.Save(aiQueue)
+	}, func(app core.App) error {
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		aiQueue.RemoveIndex("idx_queue_dedupe_pending")
+		aiQueue.Fields.RemoveByName("dedupe_key")
+
+		return app.Save(aiQueue)
+	})
+}
@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"slices"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Processing Queue - Jobs cancelled because their entry was deleted
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		if status, ok := aiQueue.Fields.GetByName("status").(*core.SelectField); ok {
+			if !slices.Contains(status.Values, "cancelled") {
+				status.Values = append(status.Values, "cancelled")
+			}
+		}
+
+		if err := app.Save(aiQueue); err != nil {
+			return err
+		}
+
+		// ================================================================
+		// Growth Analysis - Summaries whose entries were all deleted
+		// ================================================================
+		growthAnalysis, err := app.FindCollectionByNameOrId("growth_analysis")
+		if err != nil {
+			return err
+		}
+
+		growthAnalysis.Fields.Add(&core.BoolField{
+			Name: "stale",
+		})
+
+		return app.Save(growthAnalysis)
+	}, func(app core.App) error {
+		if growthAnalysis, err := app.FindCollectionByNameOrId("growth_analysis"); err == nil {
+			growthAnalysis.Fields.RemoveByName("stale")
+			if err := app.Save(growthAnalysis); err != nil {
+				return err
+			}
+		}
+
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		// Cancelled jobs count as failed without the status
+		if _, err := app.DB().NewQuery("UPDATE ai_processing_queue SET status = 'failed' WHERE status = 'cancelled'").Execute(); err != nil {
+			return err
+		}
+
+		status, ok := aiQueue.Fields.GetByName("status").(*core.SelectField)
+		if !ok {
+			return nil
+		}
+
+		status.Values = slices.DeleteFunc(status.Values, func(v string) bool {
+			return v == "cancelled"
+		})
+
+		return app.Save(aiQueue)
+	})
+}
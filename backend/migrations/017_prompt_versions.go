@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// Growth Analysis - Prompt template version
+		// ================================================================
+		growthAnalysis, err := app.FindCollectionByNameOrId("growth_analysis")
+		if err != nil {
+			return err
+		}
+
+		// Version of the prompt template the analysis was generated with
+		growthAnalysis.Fields.Add(&core.TextField{
+			Name: "prompt_version",
+			Max:  50,
+		})
+
+		growthAnalysis.AddIndex("idx_growth_analysis_prompt_version", false, "analysis_type, prompt_version", "")
+
+		return app.Save(growthAnalysis)
+	}, func(app core.App) error {
+		growthAnalysis, err := app.FindCollectionByNameOrId("growth_analysis")
+		if err != nil {
+			return nil
+		}
+
+		growthAnalysis.RemoveIndex("idx_growth_analysis_prompt_version")
+		growthAnalysis.Fields.RemoveByName("prompt_version")
+
+		return app.Save(growthAnalysis)
+	})
+}
@@ -0,0 +1,42 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// Users - Streak freezes
+		// ================================================================
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// Freezes held, earned at streak milestones
+		users.Fields.Add(&core.NumberField{
+			Name:    "streak_freezes",
+			OnlyInt: true,
+		})
+
+		// Freezes spent on missed days
+		users.Fields.Add(&core.NumberField{
+			Name:    "streak_freezes_used",
+			OnlyInt: true,
+		})
+
+		return app.Save(users)
+	}, func(app core.App) error {
+		users, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return nil
+		}
+
+		users.Fields.RemoveByName("streak_freezes")
+		users.Fields.RemoveByName("streak_freezes_used")
+
+		return app.Save(users)
+	})
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// AI Processing Queue - Client-delegated analysis runs
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		// When /analyze ran for an awaiting_client job; it runs once per hand-over
+		aiQueue.Fields.Add(&core.DateField{
+			Name: "client_analyzed_at",
+		})
+
+		return app.Save(aiQueue)
+	}, func(app core.App) error {
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		aiQueue.Fields.RemoveByName("client_analyzed_at")
+
+		return app.Save(aiQueue)
+	})
+}
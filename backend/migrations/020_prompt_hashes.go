@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// ================================================================
+		// Growth Analysis - Prompt template content hash
+		// ================================================================
+		growthAnalysis, err := app.FindCollectionByNameOrId("growth_analysis")
+		if err != nil {
+			return err
+		}
+
+		// Hash of the template content, changes when an override edits a version
+		growthAnalysis.Fields.Add(&core.TextField{
+			Name: "prompt_hash",
+			Max:  64,
+		})
+
+		if err := app.Save(growthAnalysis); err != nil {
+			return err
+		}
+
+		// ================================================================
+		// AI Processing Queue - Prompt rendered for client analysis
+		// ================================================================
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return err
+		}
+
+		// Prompt /analyze rendered, stored on the analysis at /complete
+		aiQueue.Fields.Add(&core.TextField{
+			Name: "prompt_version",
+			Max:  50,
+		})
+		aiQueue.Fields.Add(&core.TextField{
+			Name: "prompt_hash",
+			Max:  64,
+		})
+
+		return app.Save(aiQueue)
+	}, func(app core.App) error {
+		if growthAnalysis, err := app.FindCollectionByNameOrId("growth_analysis"); err == nil {
+			growthAnalysis.Fields.RemoveByName("prompt_hash")
+			if err := app.Save(growthAnalysis); err != nil {
+				return err
+			}
+		}
+
+		aiQueue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+		if err != nil {
+			return nil
+		}
+
+		aiQueue.Fields.RemoveByName("prompt_version")
+		aiQueue.Fields.RemoveByName("prompt_hash")
+
+		return app.Save(aiQueue)
+	})
+}
@@ -0,0 +1,258 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/spf13/cobra"
+)
+
+// Maximum number of attempts kept in a job's error_history
+const maxErrorHistory = 50
+
+// jobErrorAttempt is one item of a job's error_history
+type jobErrorAttempt struct {
+	Attempt int    `json:"attempt"`
+	Error   string `json:"error"`
+	At      string `json:"at"`
+}
+
+// appendJobError records a failed attempt in the job's error_history
+func appendJobError(job *core.Record, errorMsg string) {
+	var history []jobErrorAttempt
+	_ = job.UnmarshalJSONField("error_history", &history)
+
+	history = append(history, jobErrorAttempt{
+		Attempt: job.GetInt("attempts"),
+		Error:   errorMsg,
+		At:      time.Now().UTC().Format(time.RFC3339),
+	})
+	if len(history) > maxErrorHistory {
+		history = history[len(history)-maxErrorHistory:]
+	}
+
+	job.Set("error_history", history)
+}
+
+// replayFilter selects failed jobs to replay. Empty fields match everything.
+type replayFilter struct {
+	JobType       string `json:"job_type"`
+	User          string `json:"user"`
+	From          string `json:"from"` // failed at or after (date or datetime)
+	To            string `json:"to"`   // failed before (date or datetime)
+	ErrorContains string `json:"error_contains"`
+}
+
+// expression builds the record filter for failed jobs matching f
+func (f replayFilter) expression() (string, map[string]any, error) {
+	expr := []string{"status = 'failed'"}
+	params := map[string]any{}
+
+	if f.JobType != "" {
+		expr = append(expr, "job_type = {:jobType}")
+		params["jobType"] = f.JobType
+	}
+	if f.User != "" {
+		expr = append(expr, "user = {:user}")
+		params["user"] = f.User
+	}
+	if f.From != "" {
+		from, err := types.ParseDateTime(f.From)
+		if err != nil || from.IsZero() {
+			return "", nil, fmt.Errorf("invalid from date %q", f.From)
+		}
+		expr = append(expr, "completed_at >= {:from}")
+		params["from"] = from.String()
+	}
+	if f.To != "" {
+		to, err := types.ParseDateTime(f.To)
+		if err != nil || to.IsZero() {
+			return "", nil, fmt.Errorf("invalid to date %q", f.To)
+		}
+		expr = append(expr, "completed_at < {:to}")
+		params["to"] = to.String()
+	}
+	if f.ErrorContains != "" {
+		expr = append(expr, "error_message ~ {:errorContains}")
+		params["errorContains"] = f.ErrorContains
+	}
+
+	return strings.Join(expr, " && "), params, nil
+}
+
+// findDeadLetterJobs returns the failed jobs matching the filter
+func findDeadLetterJobs(app core.App, filter replayFilter) ([]*core.Record, error) {
+	expr, params, err := filter.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	return app.FindRecordsByFilter("ai_processing_queue", expr, "completed_at", 0, 0, params)
+}
+
+// replayFailedJobs puts the failed jobs matching the filter back to pending.
+// The error history is kept; attempts start over so the retry policy applies
+// again. Jobs whose dedupe_key already has a waiting job are skipped, that job
+// covers them. With dryRun set, the jobs are only counted. It returns the
+// number of replayed and skipped jobs.
+func replayFailedJobs(app core.App, filter replayFilter, dryRun bool) (int, int, error) {
+	jobs, err := findDeadLetterJobs(app, filter)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if dryRun {
+		replay, err := replayableJobs(app, jobs)
+		return len(replay), len(jobs) - len(replay), err
+	}
+
+	var replay []*core.Record
+	now := time.Now().UTC()
+	err = app.RunInTransaction(func(txApp core.App) error {
+		replay, err = replayableJobs(txApp, jobs)
+		if err != nil {
+			return err
+		}
+
+		for _, job := range replay {
+			job.Set("status", "pending")
+			job.Set("attempts", 0)
+			job.Set("scheduled_at", now)
+			job.Set("started_at", "")
+			job.Set("completed_at", "")
+			job.Set("error_message", "")
+			clearJobLease(job)
+			if err := txApp.Save(job); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	skipped := len(jobs) - len(replay)
+	log.Printf("🔁 Replayed %d failed AI jobs (%d skipped as duplicates)", len(replay), skipped)
+	return len(replay), skipped, nil
+}
+
+// replayableJobs drops the failed jobs whose dedupe_key already has a pending
+// or awaiting_client job, or appears on an earlier job of the list, since at
+// most one pending job may exist per key
+func replayableJobs(app core.App, jobs []*core.Record) ([]*core.Record, error) {
+	replay := make([]*core.Record, 0, len(jobs))
+	seen := map[string]bool{}
+
+	for _, job := range jobs {
+		key := job.GetString("dedupe_key")
+		if key == "" {
+			replay = append(replay, job)
+			continue
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		_, err := app.FindFirstRecordByFilter(
+			"ai_processing_queue",
+			"dedupe_key = {:key} && (status = 'pending' || status = 'awaiting_client')",
+			map[string]any{"key": key},
+		)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		replay = append(replay, job)
+	}
+
+	return replay, nil
+}
+
+// RegisterDeadLetterRoutes registers the superuser endpoint to replay failed
+// jobs. The failed jobs themselves are listed by the ai_dead_letter_queue view.
+func RegisterDeadLetterRoutes(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		group := se.Router.Group("/api/ai/dead-letter")
+		group.Bind(apis.RequireSuperuserAuth())
+
+		// Replay failed jobs matching the filter through normal scheduling
+		group.POST("/replay", func(e *core.RequestEvent) error {
+			body := struct {
+				replayFilter
+				DryRun bool `json:"dry_run"`
+			}{}
+			if err := e.BindBody(&body); err != nil {
+				return e.BadRequestError("Invalid request body.", err)
+			}
+
+			count, skipped, err := replayFailedJobs(e.App, body.replayFilter, body.DryRun)
+			if err != nil {
+				return e.BadRequestError("Failed to replay jobs.", err)
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"replayed": count,
+				"skipped":  skipped,
+				"dry_run":  body.DryRun,
+			})
+		})
+
+		return se.Next()
+	})
+}
+
+// NewReplayFailedJobsCommand creates the "replay-failed-jobs" CLI subcommand
+func NewReplayFailedJobsCommand(app core.App) *cobra.Command {
+	var filter replayFilter
+	var dryRun bool
+
+	command := &cobra.Command{
+		Use:          "replay-failed-jobs",
+		Short:        "Put failed AI queue jobs matching the filters back to pending",
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			// Accept either a user id or an email
+			if strings.Contains(filter.User, "@") {
+				user, err := app.FindAuthRecordByEmail("users", filter.User)
+				if err != nil {
+					return errors.New("user not found: " + filter.User)
+				}
+				filter.User = user.Id
+			}
+
+			count, skipped, err := replayFailedJobs(app, filter, dryRun)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				fmt.Printf("%d failed jobs would be replayed, %d skipped as duplicates (dry run)\n", count, skipped)
+			} else {
+				fmt.Printf("Replayed %d failed jobs, %d skipped as duplicates\n", count, skipped)
+			}
+			return nil
+		},
+	}
+
+	command.Flags().StringVar(&filter.JobType, "type", "", "job type, e.g. entry_analysis")
+	command.Flags().StringVar(&filter.User, "user", "", "user id or email")
+	command.Flags().StringVar(&filter.From, "from", "", "failed at or after, e.g. 2025-01-01")
+	command.Flags().StringVar(&filter.To, "to", "", "failed before, e.g. 2025-02-01")
+	command.Flags().StringVar(&filter.ErrorContains, "error", "", "error message substring")
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "only count the matching jobs")
+
+	return command
+}
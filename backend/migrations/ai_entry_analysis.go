@@ -0,0 +1,268 @@
+package migrations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Timeout for a single AI provider call
+const aiRequestTimeout = 2 * time.Minute
+
+// Prefix used by the development seeder for placeholder (unencrypted) content
+const seededContentPrefix = "[ENCRYPTED]"
+
+// errEntryContentEncrypted is returned when an entry's content cannot be read by the server
+var errEntryContentEncrypted = errors.New("entry content is encrypted and the user has not granted an analysis key")
+
+// entryAnalysisResult is the structured output expected from the model
+type entryAnalysisResult struct {
+	Tone             string   `json:"tone"`
+	Themes           []string `json:"themes"`
+	GrowthIndicators []string `json:"growth_indicators"`
+	Insight          string   `json:"insight"`
+	GrowthScore      float64  `json:"growth_score"`
+	EmotionalTrend   string   `json:"emotional_trend"`
+	ActionItems      []string `json:"action_items"`
+}
+
+// processEntryAnalysis analyzes a single journal entry and stores the result
+// as an entry-level growth_analysis record
+func processEntryAnalysis(ctx context.Context, app core.App, job *core.Record, keyring *analysisKeyring) error {
+	log.Printf("🔍 Processing entry analysis for job %s", job.Id)
+
+	entryID := job.GetString("entry_id")
+	if entryID == "" {
+		return permanentError(fmt.Errorf("job %s has no entry_id", job.Id))
+	}
+
+	// 1. Fetch the journal entry
+	entry, err := app.FindRecordById("journal_entries", entryID)
+	if err != nil {
+		return permanentError(fmt.Errorf("entry %s not found: %w", entryID, err))
+	}
+
+	// 2. Resolve the readable content
+	content, err := entryContentForAnalysis(entry, keyring)
+	if err != nil {
+		return err
+	}
+
+	// 3. Send to the AI provider with the user's entry analysis prompt
+	prompt, rendered, err := renderPrompt(app, "entry_analysis", entry.GetString("user"), entryPromptData{Content: content})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, aiRequestTimeout)
+	defer cancel()
+
+	resp, err := aiProvider.Generate(ctx, AIRequest{
+		Prompt:          prompt,
+		Temperature:     0.4,
+		MaxOutputTokens: entryAnalysisMaxTokens,
+	})
+	if err != nil {
+		return err
+	}
+	recordJobUsage(job, resp)
+
+	result, err := parseEntryAnalysis(resp.Text)
+	if err != nil {
+		return err
+	}
+
+	// 4. Store result in growth_analysis collection
+	if err := saveEntryAnalysis(app, entry, result, rendered, keyring); err != nil {
+		return err
+	}
+
+	// 5. Update entry's ai_processed flag (without re-triggering entry hooks)
+	entry.Set("ai_processed", true)
+	if err := app.UnsafeWithoutHooks().Save(entry); err != nil {
+		return err
+	}
+
+	log.Printf("✅ Entry %s analyzed (score: %.0f, trend: %s)", entry.Id, result.GrowthScore, result.EmotionalTrend)
+	return nil
+}
+
+// entryContentForAnalysis returns the plaintext content of an entry.
+// Development seed data is stored unencrypted; real entries can only be read
+// when the user opted in to server-side decryption.
+func entryContentForAnalysis(entry *core.Record, keyring *analysisKeyring) (string, error) {
+	content := entry.GetString("encrypted_content")
+	if strings.HasPrefix(content, seededContentPrefix) {
+		return strings.TrimPrefix(content, seededContentPrefix), nil
+	}
+
+	key, err := keyring.Key()
+	if err != nil {
+		return "", err
+	}
+
+	// A wrong key or corrupted content won't decrypt on retry either
+	plaintext, err := decryptEntryContent(content, key)
+	if err != nil {
+		return "", permanentError(err)
+	}
+	return plaintext, nil
+}
+
+// entryNeedsClient reports whether the entry of an entry_analysis job can
+// only be read by the client: it isn't seed data and the user has no valid
+// analysis key. Other failures are left for processEntryAnalysis to report.
+func entryNeedsClient(app core.App, job *core.Record, keyring *analysisKeyring) bool {
+	entry, err := app.FindRecordById("journal_entries", job.GetString("entry_id"))
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(entry.GetString("encrypted_content"), seededContentPrefix) {
+		return false
+	}
+
+	_, err = keyring.Key()
+	return errors.Is(err, errEntryContentEncrypted)
+}
+
+// parseEntryAnalysis parses and normalizes the model output
+func parseEntryAnalysis(text string) (*entryAnalysisResult, error) {
+	raw, err := extractJSONObject(text)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &entryAnalysisResult{}
+	if err := json.Unmarshal([]byte(raw), result); err != nil {
+		return nil, fmt.Errorf("invalid entry analysis JSON: %w", err)
+	}
+
+	result.GrowthScore = clampScore(result.GrowthScore)
+	result.EmotionalTrend = normalizeEmotionalTrend(result.EmotionalTrend, result.Tone)
+	if result.Themes == nil {
+		result.Themes = []string{}
+	}
+	if result.ActionItems == nil {
+		result.ActionItems = []string{}
+	}
+
+	return result, nil
+}
+
+// entryAnalysisRecord returns the entry-level growth_analysis record of an
+// entry, or a new one when the entry wasn't analyzed yet. Re-analyzing an
+// edited entry replaces its analysis, so rollups count every entry once.
+func entryAnalysisRecord(app core.App, entry *core.Record) (*core.Record, error) {
+	analysis, err := app.FindFirstRecordByFilter(
+		"growth_analysis",
+		"user = {:userId} && analysis_type = 'entry' && related_entries ~ {:entryId}",
+		map[string]any{
+			"userId":  entry.GetString("user"),
+			"entryId": entry.Id,
+		},
+	)
+	if err == nil {
+		return analysis, nil
+	}
+
+	collection, err := app.FindCollectionByNameOrId("growth_analysis")
+	if err != nil {
+		return nil, err
+	}
+
+	analysis = core.NewRecord(collection)
+	analysis.Set("user", entry.GetString("user"))
+	analysis.Set("analysis_type", "entry")
+	return analysis, nil
+}
+
+// saveEntryAnalysis stores the entry-level growth_analysis record. The
+// free-text insight is only kept when it can be encrypted with the user's key.
+func saveEntryAnalysis(app core.App, entry *core.Record, result *entryAnalysisResult, rendered renderedPrompt, keyring *analysisKeyring) error {
+	// Dated on the user's local calendar, like the periods rollups cover
+	entryDate, ok := EntryLocalDate(app, entry)
+	if !ok {
+		return permanentError(fmt.Errorf("entry %s has no entry_date", entry.Id))
+	}
+
+	analysis, err := entryAnalysisRecord(app, entry)
+	if err != nil {
+		return err
+	}
+
+	analysis.Set("period_start", entryDate)
+	analysis.Set("period_end", entryDate)
+	analysis.Set("growth_score", result.GrowthScore)
+	analysis.Set("key_themes", result.Themes)
+	analysis.Set("emotional_trend", result.EmotionalTrend)
+	analysis.Set("action_items", result.ActionItems)
+	analysis.Set("related_entries", []string{entry.Id})
+	rendered.set(analysis)
+	analysis.Set("encrypted_insights", "")
+
+	if keyring.HasKey() {
+		insights, err := json.Marshal(map[string]any{
+			"tone":              result.Tone,
+			"growth_indicators": result.GrowthIndicators,
+			"insight":           result.Insight,
+		})
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := encryptEntryContent(string(insights), keyring.key)
+		if err != nil {
+			return err
+		}
+		analysis.Set("encrypted_insights", encrypted)
+	}
+
+	return app.Save(analysis)
+}
+
+// extractJSONObject returns the outermost JSON object in a model response,
+// stripping any surrounding prose or markdown code fences
+func extractJSONObject(text string) (string, error) {
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start == -1 || end <= start {
+		return "", errors.New("no JSON object found in model response")
+	}
+	return text[start : end+1], nil
+}
+
+// clampScore keeps a growth score within 0-100
+func clampScore(score float64) float64 {
+	if score < 0 {
+		return 0
+	}
+	if score > 100 {
+		return 100
+	}
+	return score
+}
+
+// normalizeEmotionalTrend maps the model output to the emotional_trend select values
+func normalizeEmotionalTrend(trend, tone string) string {
+	switch strings.ToLower(strings.TrimSpace(trend)) {
+	case "improving", "stable", "declining":
+		return strings.ToLower(strings.TrimSpace(trend))
+	}
+
+	// Fall back to the entry tone
+	switch strings.ToLower(strings.TrimSpace(tone)) {
+	case "positive":
+		return "improving"
+	case "negative":
+		return "declining"
+	default:
+		return "stable"
+	}
+}
@@ -0,0 +1,189 @@
+package migrations
+
+import (
+	"log"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// EntryAIReferences lists the queue jobs and analyses that point at an entry.
+// They have to be collected before the entry is deleted, because the delete
+// clears the relations.
+type EntryAIReferences struct {
+	EntryID     string
+	JobIDs      []string
+	AnalysisIDs []string
+}
+
+// FindEntryAIReferences collects the unfinished jobs and the analyses of an entry
+func FindEntryAIReferences(app core.App, entryID string) (*EntryAIReferences, error) {
+	refs := &EntryAIReferences{EntryID: entryID}
+
+	jobs, err := app.FindRecordsByFilter(
+		"ai_processing_queue",
+		"(entry_id = {:entryId} || dedupe_key = {:dedupeKey}) && (status = 'pending' || status = 'awaiting_client' || status = 'processing')",
+		"",
+		0,
+		0,
+		map[string]any{
+			"entryId":   entryID,
+			"dedupeKey": JobDedupeKey("entry_analysis", entryID),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		refs.JobIDs = append(refs.JobIDs, job.Id)
+	}
+
+	analyses, err := app.FindRecordsByFilter(
+		"growth_analysis",
+		"related_entries ~ {:entryId}",
+		"",
+		0,
+		0,
+		map[string]any{"entryId": entryID},
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, analysis := range analyses {
+		refs.AnalysisIDs = append(refs.AnalysisIDs, analysis.Id)
+	}
+
+	return refs, nil
+}
+
+// Release cancels the collected jobs and cleans up analyses left without
+// entries. Call it once the entry is deleted.
+func (r *EntryAIReferences) Release(app core.App) error {
+	if err := cancelJobs(app, r.JobIDs, "entry deleted"); err != nil {
+		return err
+	}
+	return cleanupOrphanedAnalyses(app, r.AnalysisIDs)
+}
+
+// cancelJobs moves unfinished jobs to cancelled. A running job loses its
+// lease, so the worker discards its result when it finishes.
+func cancelJobs(app core.App, jobIDs []string, reason string) error {
+	if len(jobIDs) == 0 {
+		return nil
+	}
+
+	ids := make([]any, len(jobIDs))
+	for i, id := range jobIDs {
+		ids[i] = id
+	}
+
+	result, err := app.DB().Update(
+		"ai_processing_queue",
+		dbx.Params{
+			"status":           "cancelled",
+			"error_message":    reason,
+			"completed_at":     time.Now().UTC().Format(types.DefaultDateLayout),
+			"locked_by":        "",
+			"lease_expires_at": "",
+		},
+		dbx.And(
+			dbx.In("id", ids...),
+			dbx.In("status", "pending", "awaiting_client", "processing"),
+		),
+	).Execute()
+	if err != nil {
+		return err
+	}
+
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		log.Printf("🚫 Cancelled %d AI jobs: %s", affected, reason)
+		notifyJobStatusByID(app, jobIDs...)
+	}
+	return nil
+}
+
+// cleanupOrphanedAnalyses handles analyses whose related entries are all
+// gone. Entry analyses are deleted, period summaries are kept but marked
+// stale so they are no longer used as rollup sources, and their period's job
+// is queued again to regenerate them from the entries left.
+func cleanupOrphanedAnalyses(app core.App, analysisIDs []string) error {
+	if len(analysisIDs) == 0 {
+		return nil
+	}
+
+	analyses, err := app.FindRecordsByIds("growth_analysis", analysisIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, analysis := range analyses {
+		if len(analysis.GetStringSlice("related_entries")) > 0 {
+			continue
+		}
+
+		if analysis.GetString("analysis_type") == "entry" {
+			if err := app.Delete(analysis); err != nil {
+				return err
+			}
+			log.Printf("🗑️  Deleted analysis %s of a deleted entry", analysis.Id)
+			continue
+		}
+
+		analysis.Set("stale", true)
+		if err := app.Save(analysis); err != nil {
+			return err
+		}
+		log.Printf("⚠️  Marked %s analysis %s stale, all its entries were deleted", analysis.GetString("analysis_type"), analysis.Id)
+
+		if err := requeueRollupPeriod(app, analysis); err != nil {
+			log.Printf("Warning: Failed to queue regeneration of analysis %s: %v", analysis.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// requeueRollupPeriod puts the job of a summary's period back to pending, or
+// queues one when there is none. The job can't be enqueued again by the
+// scheduler, which keeps one job per user, type and period.
+func requeueRollupPeriod(app core.App, analysis *core.Record) error {
+	jobType := analysis.GetString("analysis_type") + "_analysis"
+	userID := analysis.GetString("user")
+	start := analysis.GetDateTime("period_start").Time()
+
+	job, err := app.FindFirstRecordByFilter(
+		"ai_processing_queue",
+		"user = {:userId} && job_type = {:jobType} && period_start = {:start}",
+		map[string]any{
+			"userId":  userID,
+			"jobType": jobType,
+			"start":   start.UTC().Format(types.DefaultDateLayout),
+		},
+	)
+	if err != nil {
+		for _, recurring := range recurringJobs {
+			if recurring.jobType == jobType {
+				end := analysis.GetDateTime("period_end").Time().Add(time.Second)
+				_, err := enqueueRecurringJob(app, userID, recurring, start, end, time.Now())
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Not run yet, it will see the current entries
+	if status := job.GetString("status"); status == "pending" || status == "processing" {
+		return nil
+	}
+
+	job.Set("status", "pending")
+	job.Set("attempts", 0)
+	job.Set("scheduled_at", time.Now().UTC())
+	job.Set("started_at", "")
+	job.Set("completed_at", "")
+	job.Set("error_message", "")
+	clearJobLease(job)
+	return app.Save(job)
+}
@@ -0,0 +1,104 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// JobDedupeKey returns the uniqueness key of a job type and its target
+func JobDedupeKey(jobType, targetID string) string {
+	return jobType + ":" + targetID
+}
+
+// EnqueueUniqueJob saves a new pending job unless a job with the same
+// dedupe_key is still waiting (pending or awaiting_client), in which case that
+// job is replaced by the new request. It reports whether a job was replaced.
+func EnqueueUniqueJob(app core.App, job *core.Record) (bool, error) {
+	key := job.GetString("dedupe_key")
+	if key == "" {
+		return false, app.Save(job)
+	}
+
+	// One retry covers a concurrent request creating the pending job first
+	for attempt := 0; ; attempt++ {
+		existing, err := app.FindFirstRecordByFilter(
+			"ai_processing_queue",
+			"dedupe_key = {:key} && (status = 'pending' || status = 'awaiting_client')",
+			map[string]any{"key": key},
+		)
+		if err == nil {
+			return true, app.Save(replacePendingJob(existing, job))
+		}
+
+		err = app.Save(job)
+		if err == nil || attempt > 0 || !isUniqueConstraintError(err) {
+			return false, err
+		}
+	}
+}
+
+// replacePendingJob copies the scheduling fields of a new request onto the
+// waiting job it replaces
+func replacePendingJob(existing, job *core.Record) *core.Record {
+	existing.Set("status", "pending")
+	existing.Set("priority", max(existing.GetInt("priority"), job.GetInt("priority")))
+	existing.Set("scheduled_at", job.Get("scheduled_at"))
+	existing.Set("estimated_tokens", job.Get("estimated_tokens"))
+	existing.Set("attempts", 0)
+	existing.Set("error_message", "")
+	existing.Set("sla_warned_at", "")
+	existing.Set("client_analyzed_at", "")
+	return existing
+}
+
+// Reason recorded on jobs cancelled by cancelIfSuperseded
+const supersededJobReason = "superseded by a newer request"
+
+// hasWaitingDuplicate reports whether another job with the same dedupe_key is
+// waiting (pending or awaiting_client). That job was queued by a request made
+// while this one ran, and covers it.
+func hasWaitingDuplicate(app core.App, job *core.Record) (bool, error) {
+	key := job.GetString("dedupe_key")
+	if key == "" {
+		return false, nil
+	}
+
+	_, err := app.FindFirstRecordByFilter(
+		"ai_processing_queue",
+		"dedupe_key = {:key} && id != {:id} && (status = 'pending' || status = 'awaiting_client')",
+		map[string]any{"key": key, "id": job.Id},
+	)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return false, err
+}
+
+// cancelIfSuperseded cancels a running job instead of putting it back to
+// waiting when a newer job with the same dedupe_key is already waiting, since
+// at most one pending job may exist per key. It reports whether the job was
+// cancelled.
+func cancelIfSuperseded(app core.App, job *core.Record) (bool, error) {
+	superseded, err := hasWaitingDuplicate(app, job)
+	if err != nil || !superseded {
+		return false, err
+	}
+
+	job.Set("status", "cancelled")
+	job.Set("error_message", supersededJobReason)
+	job.Set("completed_at", time.Now().UTC().Format(time.RFC3339))
+	clearJobLease(job)
+	if err := app.Save(job); err != nil {
+		return false, err
+	}
+
+	log.Printf("🚫 Job %s cancelled: %s", job.Id, supersededJobReason)
+	return true, nil
+}
@@ -0,0 +1,127 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+// enqueueEntryAnalysis queues an entry analysis job the way the entry hooks do
+func enqueueEntryAnalysis(t testing.TB, app core.App, userID, entryID string) {
+	queue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := core.NewRecord(queue)
+	job.Set("user", userID)
+	job.Set("job_type", "entry_analysis")
+	job.Set("entry_id", entryID)
+	job.Set("status", "pending")
+	job.Set("priority", 5)
+	job.Set("scheduled_at", time.Now().UTC().Add(-time.Second))
+	job.Set("dedupe_key", JobDedupeKey("entry_analysis", entryID))
+	if _, err := EnqueueUniqueJob(app, job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// createTestEntry saves a user with one journal entry
+func createTestEntry(t testing.TB, app core.App, email string) (*core.Record, *core.Record) {
+	users, err := app.FindCollectionByNameOrId("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := app.FindCollectionByNameOrId("journal_entries")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := core.NewRecord(users)
+	user.SetEmail(email)
+	user.SetPassword("1234567890")
+	if err := app.Save(user); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := core.NewRecord(entries)
+	entry.Set("user", user.Id)
+	entry.Set("entry_date", time.Now().UTC())
+	entry.Set("encrypted_content", "iv:content")
+	if err := app.Save(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	return user, entry
+}
+
+func TestEditWhileJobRuns(t *testing.T) {
+	app, err := tests.NewTestApp(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Cleanup()
+
+	scenarios := []struct {
+		name   string
+		finish func(app core.App, job *core.Record) error
+	}{
+		{"retry", func(app core.App, job *core.Record) error {
+			return retryOrFailJob(app, job, errors.New("temporary failure"))
+		}},
+		{"awaiting client", markJobAwaitingClient},
+		{"release", func(app core.App, job *core.Record) error {
+			_, err := releaseHeldJobs(app)
+			return err
+		}},
+	}
+
+	for i, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			user, entry := createTestEntry(t, app, fmt.Sprintf("edit%d@example.com", i))
+
+			enqueueEntryAnalysis(t, app, user.Id, entry.Id)
+			first, err := app.FindFirstRecordByData("ai_processing_queue", "entry_id", entry.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			running, err := claimJob(app, first.Id)
+			if err != nil || running == nil {
+				t.Fatalf("Expected the job to be claimed, got %v", err)
+			}
+
+			// The entry is edited while its analysis runs
+			enqueueEntryAnalysis(t, app, user.Id, entry.Id)
+
+			if err := s.finish(app, running); err != nil {
+				t.Fatal(err)
+			}
+
+			jobs, err := app.FindRecordsByFilter(
+				"ai_processing_queue", "entry_id = {:id}", "", 0, 0,
+				map[string]any{"id": entry.Id},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(jobs) != 2 {
+				t.Fatalf("Expected 2 jobs, got %d", len(jobs))
+			}
+
+			for _, job := range jobs {
+				expected := "pending"
+				if job.Id == first.Id {
+					expected = "cancelled"
+				}
+				if status := job.GetString("status"); status != expected {
+					t.Errorf("Expected job %s to be %s, got %s", job.Id, expected, status)
+				}
+			}
+		})
+	}
+}
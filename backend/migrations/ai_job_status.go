@@ -0,0 +1,230 @@
+package migrations
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/routine"
+	"github.com/pocketbase/pocketbase/tools/subscriptions"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Realtime topic that pushes the status of the subscriber's own jobs
+const jobStatusTopic = "ai_jobs"
+
+// jobStatus is the state of a job as shown to its owner
+type jobStatus struct {
+	ID            string     `json:"id"`
+	JobType       string     `json:"job_type"`
+	EntryID       string     `json:"entry_id"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	ScheduledAt   string     `json:"scheduled_at"`
+	StartedAt     string     `json:"started_at"`
+	CompletedAt   string     `json:"completed_at"`
+	QueuePosition *int       `json:"queue_position"` // pending jobs only, 1 = next
+	ETA           *time.Time `json:"eta"`            // pending jobs only
+	LastError     string     `json:"last_error"`
+}
+
+// buildJobStatus describes a job, with its queue position and ETA while it is pending
+func buildJobStatus(app core.App, job *core.Record, now time.Time) jobStatus {
+	status := jobStatus{
+		ID:          job.Id,
+		JobType:     job.GetString("job_type"),
+		EntryID:     job.GetString("entry_id"),
+		Status:      job.GetString("status"),
+		Attempts:    job.GetInt("attempts"),
+		ScheduledAt: job.GetString("scheduled_at"),
+		StartedAt:   job.GetString("started_at"),
+		CompletedAt: job.GetString("completed_at"),
+		LastError:   job.GetString("error_message"),
+	}
+
+	if status.Status != "pending" {
+		return status
+	}
+
+	position, tokensAhead, err := jobQueuePosition(app, job, now)
+	if err != nil {
+		log.Printf("Warning: Failed to find queue position of job %s: %v", job.Id, err)
+		return status
+	}
+	status.QueuePosition = &position
+
+	if eta, ok := estimateJobStart(job, tokensAhead, now); ok {
+		status.ETA = &eta
+	}
+
+	return status
+}
+
+// jobQueuePosition returns a pending job's place among the due jobs, by the
+// same order the processor claims them in, and the tokens of the jobs ahead
+func jobQueuePosition(app core.App, job *core.Record, now time.Time) (int, float64, error) {
+	row := struct {
+		Ahead  int     `db:"ahead"`
+		Tokens float64 `db:"tokens"`
+	}{}
+
+	err := app.DB().NewQuery(`
+		SELECT COUNT(*) AS ahead, COALESCE(SUM(tokens), 0) AS tokens
+		FROM (
+			SELECT scheduled_at,
+				CASE WHEN estimated_tokens > 0 THEN estimated_tokens ELSE {:defaultTokens} END AS tokens,
+				` + effectivePriorityExpr() + ` AS effective_priority
+			FROM ai_processing_queue
+			WHERE status = 'pending' AND scheduled_at <= {:now} AND id != {:id}
+		)
+		WHERE effective_priority > {:priority}
+			OR (effective_priority = {:priority} AND scheduled_at < {:scheduledAt})
+	`).Bind(dbx.Params{
+		"id":            job.Id,
+		"now":           now.UTC().Format(types.DefaultDateLayout),
+		"priority":      effectivePriority(job, now),
+		"scheduledAt":   job.GetString("scheduled_at"),
+		"defaultTokens": defaultJobTokenEstimate,
+	}).One(&row)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return row.Ahead + 1, row.Tokens, nil
+}
+
+// estimateJobStart estimates when a pending job starts: once it is due and
+// the token bucket has refilled enough for it and every job ahead of it
+func estimateJobStart(job *core.Record, tokensAhead float64, now time.Time) (time.Time, bool) {
+	if aiTokenBucket == nil || aiTokenBucket.rate <= 0 {
+		return time.Time{}, false
+	}
+
+	available, err := aiTokenBucket.Available()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	eta := now
+	if missing := tokensAhead + jobTokenEstimate(job) - available; missing > 0 {
+		eta = now.Add(time.Duration(missing / aiTokenBucket.rate * float64(time.Second)))
+	}
+	if scheduledAt := job.GetDateTime("scheduled_at").Time(); scheduledAt.After(eta) {
+		eta = scheduledAt
+	}
+
+	return eta.UTC().Truncate(time.Second), true
+}
+
+// notifyJobStatus pushes a job's status to its owner's realtime clients
+// subscribed to the ai_jobs topic
+func notifyJobStatus(app core.App, job *core.Record) {
+	userID := job.GetString("user")
+
+	var clients []subscriptions.Client
+	for _, client := range app.SubscriptionsBroker().Clients() {
+		if len(client.Subscriptions(jobStatusTopic)) == 0 {
+			continue
+		}
+		if auth, _ := client.Get(apis.RealtimeClientAuthKey).(*core.Record); auth != nil && auth.Id == userID {
+			clients = append(clients, client)
+		}
+	}
+	if len(clients) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(buildJobStatus(app, job, time.Now()))
+	if err != nil {
+		log.Printf("Warning: Failed to encode status of job %s: %v", job.Id, err)
+		return
+	}
+
+	msg := subscriptions.Message{Name: jobStatusTopic, Data: data}
+	for _, client := range clients {
+		routine.FireAndForget(func() {
+			client.Send(msg)
+		})
+	}
+}
+
+// notifyJobStatusByID reloads jobs changed with raw queries and pushes their status
+func notifyJobStatusByID(app core.App, jobIDs ...string) {
+	if len(jobIDs) == 0 || app.SubscriptionsBroker().TotalClients() == 0 {
+		return
+	}
+
+	jobs, err := app.FindRecordsByIds("ai_processing_queue", jobIDs)
+	if err != nil {
+		log.Printf("Warning: Failed to load jobs for status push: %v", err)
+		return
+	}
+
+	for _, job := range jobs {
+		notifyJobStatus(app, job)
+	}
+}
+
+// RegisterJobStatusRoutes registers the endpoints that let users follow their
+// own AI jobs, and pushes status changes to the ai_jobs realtime topic
+func RegisterJobStatusRoutes(app core.App) {
+	// Status changes saved as records (queueing, completion, retries, ...).
+	// Claims and cancellations use raw queries and notify on their own.
+	app.OnRecordAfterCreateSuccess("ai_processing_queue").BindFunc(func(e *core.RecordEvent) error {
+		notifyJobStatus(e.App, e.Record)
+		return e.Next()
+	})
+	app.OnRecordAfterUpdateSuccess("ai_processing_queue").BindFunc(func(e *core.RecordEvent) error {
+		notifyJobStatus(e.App, e.Record)
+		return e.Next()
+	})
+
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		group := se.Router.Group("/api/ai/jobs")
+		group.Bind(apis.RequireAuth("users"))
+
+		// List the caller's most recent jobs, optionally for one entry or status
+		group.GET("", func(e *core.RequestEvent) error {
+			filter := "user = {:userId}"
+			params := map[string]any{"userId": e.Auth.Id}
+
+			if entryID := e.Request.URL.Query().Get("entry"); entryID != "" {
+				filter += " && entry_id = {:entryId}"
+				params["entryId"] = entryID
+			}
+			if status := e.Request.URL.Query().Get("status"); status != "" {
+				filter += " && status = {:status}"
+				params["status"] = status
+			}
+
+			jobs, err := e.App.FindRecordsByFilter("ai_processing_queue", filter, "-scheduled_at", 50, 0, params)
+			if err != nil {
+				return e.InternalServerError("Failed to load jobs.", err)
+			}
+
+			now := time.Now()
+			items := make([]jobStatus, 0, len(jobs))
+			for _, job := range jobs {
+				items = append(items, buildJobStatus(e.App, job, now))
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{"items": items})
+		})
+
+		// Status of a single job
+		group.GET("/{id}", func(e *core.RequestEvent) error {
+			job, err := e.App.FindRecordById("ai_processing_queue", e.Request.PathValue("id"))
+			if err != nil || job.GetString("user") != e.Auth.Id {
+				return e.NotFoundError("Job not found.", err)
+			}
+
+			return e.JSON(http.StatusOK, buildJobStatus(e.App, job, time.Now()))
+		})
+
+		return se.Next()
+	})
+}
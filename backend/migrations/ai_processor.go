@@ -1,6 +1,8 @@
 package migrations
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,48 +12,130 @@ import (
 	"github.com/pocketbase/pocketbase/core"
 )
 
-// TokenBucket implements rate limiting for AI API calls
+// TokenBucket implements rate limiting for AI API calls. The balance lives in
+// a tokenBucketStore: in memory for a single process, or in the database so
+// every processor sharing the provider key draws from the same budget.
 type TokenBucket struct {
-	tokens     float64
-	capacity   float64
-	rate       float64 // tokens per second
-	lastUpdate time.Time
-	mu         sync.Mutex
+	capacity float64
+	rate     float64 // tokens per second
+	store    tokenBucketStore
+}
+
+// ErrTokenRequestTooLarge is returned for requests above the bucket capacity
+var ErrTokenRequestTooLarge = errors.New("token request exceeds bucket capacity")
+
+// tokenBucketStore keeps the balance of a token bucket
+type tokenBucketStore interface {
+	// apply atomically refills the balance for the time elapsed since the
+	// last update (up to capacity) and adds delta. With strict set, a delta
+	// that would take the balance below zero is not applied. It returns
+	// whether delta was applied and the resulting balance.
+	apply(capacity, rate, delta float64, strict bool, now time.Time) (bool, float64, error)
 }
 
-// NewTokenBucket creates a new token bucket for rate limiting
+// NewTokenBucket creates a new in-memory token bucket for rate limiting
 func NewTokenBucket(capacity, rate float64) *TokenBucket {
 	return &TokenBucket{
-		tokens:     capacity,
-		capacity:   capacity,
-		rate:       rate,
-		lastUpdate: time.Now(),
+		capacity: capacity,
+		rate:     rate,
+		store:    &memoryBucketStore{tokens: capacity, lastUpdate: time.Now()},
 	}
 }
 
 // Consume attempts to consume the specified number of tokens
 // Returns true if successful, false if not enough tokens available
 func (tb *TokenBucket) Consume(tokens float64) bool {
-	tb.mu.Lock()
-	defer tb.mu.Unlock()
+	ok, _, err := tb.store.apply(tb.capacity, tb.rate, -tokens, true, time.Now())
+	if err != nil {
+		log.Printf("Warning: Token bucket unavailable: %v", err)
+		return false
+	}
+	return ok
+}
+
+// Reserve takes tokens from the bucket right away, going into debt if needed,
+// and returns how long the caller must wait before using them. Reservations
+// are served in the order they were made. Requests larger than the capacity
+// can never be served and are rejected.
+func (tb *TokenBucket) Reserve(tokens float64) (time.Duration, error) {
+	if tokens > tb.capacity {
+		return 0, fmt.Errorf("%w: %.0f > %.0f", ErrTokenRequestTooLarge, tokens, tb.capacity)
+	}
+
+	_, balance, err := tb.store.apply(tb.capacity, tb.rate, -tokens, false, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	if balance >= 0 {
+		return 0, nil
+	}
+	return time.Duration(-balance / tb.rate * float64(time.Second)), nil
+}
+
+// Wait reserves tokens and sleeps until they are available. When ctx is done
+// first, the reservation is returned to the bucket.
+func (tb *TokenBucket) Wait(ctx context.Context, tokens float64) error {
+	delay, err := tb.Reserve(tokens)
+	if err != nil || delay == 0 {
+		return err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
 
-	now := time.Now()
-	elapsed := now.Sub(tb.lastUpdate).Seconds()
-	tb.lastUpdate = now
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		tb.Adjust(tokens)
+		return ctx.Err()
+	}
+}
+
+// Adjust returns tokens to the bucket (or debits more when negative) after
+// the real usage of a request is known. The balance may go below zero, which
+// delays later requests until the debt is refilled.
+func (tb *TokenBucket) Adjust(tokens float64) {
+	if _, _, err := tb.store.apply(tb.capacity, tb.rate, tokens, false, time.Now()); err != nil {
+		log.Printf("Warning: Failed to adjust token bucket: %v", err)
+	}
+}
+
+// Available returns the current balance after refill. It is negative while
+// reservations are waiting for tokens.
+func (tb *TokenBucket) Available() (float64, error) {
+	_, balance, err := tb.store.apply(tb.capacity, tb.rate, 0, false, time.Now())
+	return balance, err
+}
+
+// memoryBucketStore keeps the balance in process memory
+type memoryBucketStore struct {
+	tokens     float64
+	lastUpdate time.Time
+	mu         sync.Mutex
+}
+
+func (s *memoryBucketStore) apply(capacity, rate, delta float64, strict bool, now time.Time) (bool, float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
 	// Refill tokens based on elapsed time
-	tb.tokens += elapsed * tb.rate
-	if tb.tokens > tb.capacity {
-		tb.tokens = tb.capacity
+	if elapsed := now.Sub(s.lastUpdate).Seconds(); elapsed > 0 {
+		s.tokens += elapsed * rate
+	}
+	s.lastUpdate = now
+	if s.tokens > capacity {
+		s.tokens = capacity
 	}
 
 	// Check if we have enough tokens
-	if tb.tokens >= tokens {
-		tb.tokens -= tokens
-		return true
+	if strict && s.tokens+delta < 0 {
+		return false, s.tokens, nil
 	}
 
-	return false
+	s.tokens = min(s.tokens+delta, capacity)
+	return true, s.tokens, nil
 }
 
 // Global token bucket for AI rate limiting
@@ -62,127 +146,171 @@ var aiTokenBucket *TokenBucket
 func StartAIQueueProcessor(app core.App) {
 	log.Println("🚀 Starting AI Queue Processor...")
 
+	// Load the prompt templates up front, a broken override would fail every job
+	if _, err := loadPromptRegistry(app); err != nil {
+		log.Printf("❌ Failed to load prompt templates, queue processor not started: %v", err)
+		return
+	}
+
 	// Initialize token bucket from environment
 	rateLimitTokens := getEnvFloat("AI_RATE_LIMIT_TOKENS", 15000)
 	rateLimitWindow := getEnvFloat("AI_RATE_LIMIT_WINDOW", 60) // seconds
 	ratePerSecond := rateLimitTokens / rateLimitWindow
 
-	aiTokenBucket = NewTokenBucket(rateLimitTokens, ratePerSecond)
+	// The bucket is shared through the database unless AI_RATE_LIMIT_BACKEND=memory
+	backend := "sqlite"
+	if os.Getenv("AI_RATE_LIMIT_BACKEND") == "memory" {
+		backend = "memory"
+		aiTokenBucket = NewTokenBucket(rateLimitTokens, ratePerSecond)
+	} else {
+		bucket, err := NewSharedTokenBucket(app, "ai_provider", rateLimitTokens, ratePerSecond)
+		if err != nil {
+			log.Printf("❌ Failed to initialize shared token bucket, queue processor not started: %v", err)
+			return
+		}
+		aiTokenBucket = bucket
+	}
 
-	log.Printf("✅ Token bucket initialized: %.0f tokens, %.2f tokens/sec", rateLimitTokens, ratePerSecond)
+	log.Printf("✅ Token bucket initialized (%s): %.0f tokens, %.2f tokens/sec", backend, rateLimitTokens, ratePerSecond)
+
+	// Initialize AI provider from environment
+	provider, err := NewAIProviderFromEnv()
+	if err != nil {
+		log.Printf("❌ Failed to initialize AI provider, queue processor not started: %v", err)
+		return
+	}
+	aiProvider = provider
+
+	log.Printf("✅ AI provider initialized: %s", aiProvider.Name())
 
 	// Get queue processing interval from environment
 	intervalSec := getEnvFloat("QUEUE_PROCESS_INTERVAL", 5)
 	interval := time.Duration(intervalSec) * time.Second
 
-	// Start the background processor
-	ticker := time.NewTicker(interval)
-	go func() {
-		for range ticker.C {
-			processPendingJobs(app)
-		}
-	}()
+	// Start the worker pool and the poller feeding it
+	aiWorkerPool = newWorkerPool(app)
+	aiWorkerPool.start(interval)
 
-	log.Printf("✅ AI Queue Processor running (interval: %v)", interval)
-}
+	// Drain the queue when the app terminates
+	shutdownTimeout := time.Duration(getEnvFloat("AI_QUEUE_SHUTDOWN_TIMEOUT", 30) * float64(time.Second))
+	app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+		log.Printf("⏹️  Stopping AI Queue Processor (drain timeout: %v)...", shutdownTimeout)
+		aiWorkerPool.shutdown(shutdownTimeout)
+		return e.Next()
+	})
 
-// processPendingJobs processes all pending AI jobs
-func processPendingJobs(app core.App) {
-	// Find pending jobs ordered by priority (desc) and scheduled_at (asc)
-	jobs, err := app.FindRecordsByFilter(
-		"ai_processing_queue",
-		"status = {:status}",
-		"-priority,scheduled_at",
-		10, // Process up to 10 jobs at a time
-		0,
-		map[string]any{"status": "pending"},
-	)
+	// Enqueue recurring jobs (daily/weekly/monthly/growth) in each user's time zone
+	startRecurringJobScheduler(app)
 
-	if err != nil {
-		log.Printf("Error finding pending jobs: %v", err)
-		return
-	}
+	// Warn about jobs waiting longer than their job type's max wait
+	startQueueSLAMonitor(app)
 
-	if len(jobs) == 0 {
-		return
-	}
+	log.Printf("✅ AI Queue Processor running (interval: %v, workers: %d, per user: %d)", interval, aiWorkerPool.size, aiWorkerPool.perUserLimit)
+}
 
-	log.Printf("📋 Found %d pending AI jobs", len(jobs))
+// processJob processes a single claimed AI job. The context is cancelled when
+// the processor shuts down before the job could finish.
+func processJob(ctx context.Context, app core.App, job *core.Record) error {
+	// Keep the lease alive while the job runs
+	stopHeartbeat := startJobHeartbeat(app, job)
+	defer stopHeartbeat()
 
-	for _, job := range jobs {
-		if err := processJob(app, job); err != nil {
-			log.Printf("❌ Failed to process job %s: %v", job.Id, err)
-		}
+	// A job whose lease kept expiring (e.g. the processor crashed mid-run) is
+	// reclaimed with attempts already counted
+	jobType := job.GetString("job_type")
+	if policy := retryPolicyFor(jobType); job.GetInt("attempts") > policy.maxAttempts {
+		return markJobFailed(app, job, fmt.Sprintf("giving up after %d attempts (lease expired)", job.GetInt("attempts")-1))
 	}
-}
 
-// processJob processes a single AI job
-func processJob(app core.App, job *core.Record) error {
-	// Check if scheduled time has arrived
-	scheduledAt := job.GetDateTime("scheduled_at").Time()
-	if scheduledAt.IsZero() {
-		return markJobFailed(app, job, "Invalid scheduled_at time")
-	}
+	// The user's analysis key (if they opted in) is only unwrapped for the
+	// duration of this call and wiped afterwards
+	keyring := newAnalysisKeyring(app, job)
+	defer keyring.Wipe()
 
-	if time.Now().UTC().Before(scheduledAt) {
-		// Not time yet, skip
-		return nil
+	// Content the server can't read is handed over to the client right away,
+	// without holding a worker and tokens for it
+	if jobType == "entry_analysis" && entryNeedsClient(app, job, keyring) {
+		return markJobAwaitingClient(app, job)
 	}
 
-	// Get estimated tokens
-	estimatedTokens := float64(job.GetInt("estimated_tokens"))
-	if estimatedTokens == 0 {
-		estimatedTokens = 1000 // Default estimate
+	// Monthly summaries are built from the weekly ones, so they wait for the
+	// weekly jobs of their month still queued or running
+	if jobType == "monthly_analysis" {
+		waiting, err := hasUnfinishedWeeklyJobs(app, job)
+		if err != nil {
+			return retryOrFailJob(app, job, err)
+		}
+		if waiting {
+			return deferJob(app, job, weeklyRollupWait, "waiting for weekly summaries")
+		}
 	}
 
-	// Check rate limit
-	if !aiTokenBucket.Consume(estimatedTokens) {
-		log.Printf("⏳ Rate limit reached, job %s will wait", job.Id)
-		return nil
+	// Wait for the job's share of the rate limit (shared by all workers).
+	// Reservations are made in claim order, so equal-priority jobs run FIFO.
+	// The estimate is corrected with the real usage once the job ran.
+	waitStart := time.Now()
+	if err := aiTokenBucket.Wait(ctx, jobTokenEstimate(job)); err != nil {
+		switch {
+		case ctx.Err() != nil:
+			return nil // interrupted by shutdown, put back to pending by the drain
+		case errors.Is(err, ErrTokenRequestTooLarge):
+			return markJobFailed(app, job, err.Error())
+		default:
+			return retryOrFailJob(app, job, err)
+		}
 	}
-
-	// Mark job as processing
-	job.Set("status", "processing")
-	job.Set("started_at", time.Now().UTC().Format(time.RFC3339))
-	if err := app.Save(job); err != nil {
-		return err
+	if waited := time.Since(waitStart); waited >= time.Second {
+		log.Printf("⏳ Job %s waited %v for rate limit", job.Id, waited.Round(time.Second))
 	}
 
+	// Usage reported by the provider for this attempt
+	job.Set("prompt_tokens", 0)
+	job.Set("completion_tokens", 0)
+
 	// Process based on job type
-	jobType := job.GetString("job_type")
 	var err error
 
 	switch jobType {
 	case "entry_analysis":
-		err = processEntryAnalysis(app, job)
+		err = processEntryAnalysis(ctx, app, job, keyring)
 	case "daily_summary":
 		err = processDailySummary(app, job)
 	case "weekly_analysis":
-		err = processWeeklyAnalysis(app, job)
+		err = processWeeklyAnalysis(ctx, app, job)
 	case "monthly_analysis":
-		err = processMonthlyAnalysis(app, job)
+		err = processMonthlyAnalysis(ctx, app, job)
 	case "streak_update":
 		err = processStreakUpdate(app, job)
 	case "growth_calculation":
 		err = processGrowthCalculation(app, job)
 	default:
-		err = markJobFailed(app, job, "Unknown job type: "+jobType)
+		err = permanentError(errors.New("unknown job type: " + jobType))
 	}
 
-	if err != nil {
-		// Increment attempt count
-		attempts := job.GetInt("attempts")
-		job.Set("attempts", attempts+1)
+	// Replace the up-front estimate with the real usage
+	settleJobTokens(app, job)
 
-		// Max retries: 3
-		if attempts >= 3 {
-			return markJobFailed(app, job, err.Error())
-		}
+	// Interrupted by shutdown: the job is put back to pending by the drain
+	if ctx.Err() != nil {
+		log.Printf("⏹️  Job %s interrupted by shutdown", job.Id)
+		return nil
+	}
+
+	// Another processor reclaimed the job after our lease ran out
+	stopHeartbeat()
+	if !ownsJobLease(app, job) {
+		log.Printf("⚠️  Lease on job %s was lost, discarding result", job.Id)
+		return nil
+	}
 
-		// Re-queue with delay
-		job.Set("status", "pending")
-		job.Set("scheduled_at", time.Now().Add(5*time.Minute).UTC().Format(time.RFC3339))
-		return app.Save(job)
+	// Content that became unreadable meanwhile (e.g. the key expired) is
+	// handed over to the client as well
+	if errors.Is(err, errEntryContentEncrypted) {
+		return markJobAwaitingClient(app, job)
+	}
+
+	if err != nil {
+		return retryOrFailJob(app, job, err)
 	}
 
 	// Mark job as completed
@@ -193,6 +321,7 @@ func processJob(app core.App, job *core.Record) error {
 func markJobCompleted(app core.App, job *core.Record) error {
 	job.Set("status", "completed")
 	job.Set("completed_at", time.Now().UTC().Format(time.RFC3339))
+	clearJobLease(job)
 	if err := app.Save(job); err != nil {
 		return err
 	}
@@ -204,7 +333,9 @@ func markJobCompleted(app core.App, job *core.Record) error {
 func markJobFailed(app core.App, job *core.Record, errorMsg string) error {
 	job.Set("status", "failed")
 	job.Set("error_message", errorMsg)
+	appendJobError(job, errorMsg)
 	job.Set("completed_at", time.Now().UTC().Format(time.RFC3339))
+	clearJobLease(job)
 	if err := app.Save(job); err != nil {
 		return err
 	}
@@ -214,22 +345,7 @@ func markJobFailed(app core.App, job *core.Record, errorMsg string) error {
 
 // Stub functions for actual AI processing (to be implemented in Phase 4)
 // These are placeholder implementations for Phase 1
-
-func processEntryAnalysis(app core.App, job *core.Record) error {
-	log.Printf("🔍 Processing entry analysis for job %s", job.Id)
-
-	// TODO: Phase 4 - Implement actual AI Studio integration
-	// For now, just mark as completed
-
-	// Steps for Phase 4:
-	// 1. Fetch the journal entry
-	// 2. Decrypt the content (requires client key - this is complex!)
-	// 3. Send to AI Studio with entry analysis prompt
-	// 4. Store result in growth_analysis collection
-	// 5. Update entry's ai_processed flag
-
-	return nil
-}
+// Entry analysis lives in ai_entry_analysis.go, weekly/monthly rollups in ai_rollup_analysis.go
 
 func processDailySummary(app core.App, job *core.Record) error {
 	log.Printf("📊 Processing daily summary for job %s", job.Id)
@@ -237,18 +353,6 @@ func processDailySummary(app core.App, job *core.Record) error {
 	return nil
 }
 
-func processWeeklyAnalysis(app core.App, job *core.Record) error {
-	log.Printf("📈 Processing weekly analysis for job %s", job.Id)
-	// TODO: Phase 4
-	return nil
-}
-
-func processMonthlyAnalysis(app core.App, job *core.Record) error {
-	log.Printf("📉 Processing monthly analysis for job %s", job.Id)
-	// TODO: Phase 4
-	return nil
-}
-
 func processStreakUpdate(app core.App, job *core.Record) error {
 	log.Printf("🔥 Processing streak update for job %s", job.Id)
 	// TODO: Phase 3 - Recalculate streaks
@@ -0,0 +1,135 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMemoryBucketStoreApply(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	scenarios := []struct {
+		name     string
+		tokens   float64
+		elapsed  time.Duration
+		delta    float64
+		strict   bool
+		applied  bool
+		expected float64
+	}{
+		{"consume from a full bucket", 100, 0, -40, true, true, 60},
+		{"refill for the elapsed time", 20, 3 * time.Second, -10, true, true, 40},
+		{"refill is capped at capacity", 90, time.Minute, 0, true, true, 100},
+		{"strict rejects going below zero", 10, 0, -20, true, false, 10},
+		{"non-strict goes into debt", 10, 0, -30, false, true, -20},
+		{"debt is refilled over time", -20, 2 * time.Second, 0, false, true, 0},
+		{"returned tokens are capped at capacity", 95, 0, 50, false, true, 100},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			store := &memoryBucketStore{tokens: s.tokens, lastUpdate: start}
+
+			applied, balance, err := store.apply(100, 10, s.delta, s.strict, start.Add(s.elapsed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if applied != s.applied {
+				t.Errorf("Expected applied %v, got %v", s.applied, applied)
+			}
+			if balance != s.expected {
+				t.Errorf("Expected balance %.0f, got %.2f", s.expected, balance)
+			}
+		})
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	bucket := NewTokenBucket(100, 10)
+
+	// Served from the balance
+	if delay, err := bucket.Reserve(100); err != nil || delay != 0 {
+		t.Fatalf("Expected no delay, got %v (%v)", delay, err)
+	}
+
+	// Reservations queue up behind each other
+	first, err := bucket.Reserve(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := bucket.Reserve(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(first.Seconds()-5) > 0.1 {
+		t.Errorf("Expected the first reservation to wait about 5s, got %v", first)
+	}
+	if math.Abs(second.Seconds()-10) > 0.1 {
+		t.Errorf("Expected the second reservation to wait about 10s, got %v", second)
+	}
+
+	// Larger than the capacity can never be served
+	if _, err := bucket.Reserve(101); !errors.Is(err, ErrTokenRequestTooLarge) {
+		t.Errorf("Expected ErrTokenRequestTooLarge, got %v", err)
+	}
+}
+
+func TestTokenBucketAdjust(t *testing.T) {
+	bucket := NewTokenBucket(100, 10)
+	bucket.Consume(80)
+
+	// The estimate was too high: give back the unused tokens
+	bucket.Adjust(30)
+	if available, _ := bucket.Available(); math.Abs(available-50) > 1 {
+		t.Errorf("Expected about 50 tokens, got %.2f", available)
+	}
+
+	// The real usage was higher: the difference becomes debt
+	bucket.Adjust(-70)
+	if available, _ := bucket.Available(); math.Abs(available+20) > 1 {
+		t.Errorf("Expected a debt of about 20 tokens, got %.2f", available)
+	}
+	if delay, _ := bucket.Reserve(10); math.Abs(delay.Seconds()-3) > 0.1 {
+		t.Errorf("Expected the debt to delay the next reservation about 3s, got %v", delay)
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	t.Run("waits for the refill", func(t *testing.T) {
+		bucket := NewTokenBucket(100, 1000)
+		bucket.Consume(100)
+
+		start := time.Now()
+		if err := bucket.Wait(context.Background(), 50); err != nil {
+			t.Fatal(err)
+		}
+		if waited := time.Since(start); waited < 40*time.Millisecond {
+			t.Errorf("Expected to wait about 50ms, waited %v", waited)
+		}
+	})
+
+	t.Run("cancel returns the reservation", func(t *testing.T) {
+		bucket := NewTokenBucket(100, 1)
+		bucket.Consume(100)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		if err := bucket.Wait(ctx, 50); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+		}
+		if available, _ := bucket.Available(); available < 0 {
+			t.Errorf("Expected the reservation to be returned, balance is %.2f", available)
+		}
+	})
+
+	t.Run("too large fails right away", func(t *testing.T) {
+		bucket := NewTokenBucket(100, 1)
+		if err := bucket.Wait(context.Background(), 500); !errors.Is(err, ErrTokenRequestTooLarge) {
+			t.Errorf("Expected ErrTokenRequestTooLarge, got %v", err)
+		}
+	})
+}
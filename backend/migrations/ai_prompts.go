@@ -0,0 +1,253 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// embeddedPrompts holds the built-in prompt templates, named
+// <job_type>.<version>.tmpl (e.g. entry_analysis.v1.tmpl)
+//
+//go:embed prompts/*.tmpl
+var embeddedPrompts embed.FS
+
+// promptTemplate is one version of a job type's prompt
+type promptTemplate struct {
+	jobType string
+	version string
+	hash    string // of the template content, tells overrides of a version apart
+	tmpl    *template.Template
+}
+
+// renderedPrompt identifies the template a prompt was rendered from
+type renderedPrompt struct {
+	version string
+	hash    string
+}
+
+// render executes the template with the job's prompt data
+func (p *promptTemplate) render(data any) (string, error) {
+	var out strings.Builder
+	if err := p.tmpl.Execute(&out, data); err != nil {
+		return "", permanentError(fmt.Errorf("prompt %s %s: %w", p.jobType, p.version, err))
+	}
+	return out.String(), nil
+}
+
+// entryPromptData is passed to entry_analysis templates
+type entryPromptData struct {
+	Content string
+}
+
+// rollupPromptData is passed to weekly_analysis and monthly_analysis templates
+type rollupPromptData struct {
+	PeriodStart string
+	PeriodEnd   string
+	Summaries   string
+	Previous    string
+}
+
+// promptRegistry holds every known prompt version per job type
+type promptRegistry struct {
+	templates map[string]map[string]*promptTemplate
+}
+
+var (
+	promptRegistryOnce sync.Once
+	loadedPrompts      *promptRegistry
+	promptRegistryErr  error
+)
+
+// loadPromptRegistry loads the embedded prompts once, then the templates in
+// AI_PROMPTS_DIR (default: pb_data/prompts). A file on disk replaces the
+// embedded template with the same name or adds a new version; analyses record
+// the template hash next to the version, so a replaced version is told apart.
+func loadPromptRegistry(app core.App) (*promptRegistry, error) {
+	promptRegistryOnce.Do(func() {
+		registry := &promptRegistry{templates: map[string]map[string]*promptTemplate{}}
+
+		if err := registry.addFS(embeddedPrompts, "prompts"); err != nil {
+			promptRegistryErr = err
+			return
+		}
+
+		dir := os.Getenv("AI_PROMPTS_DIR")
+		if dir == "" {
+			dir = filepath.Join(app.DataDir(), "prompts")
+		}
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			if err := registry.addFS(os.DirFS(dir), "."); err != nil {
+				promptRegistryErr = err
+				return
+			}
+			log.Printf("📝 Loaded prompt overrides from %s", dir)
+		}
+
+		loadedPrompts = registry
+	})
+
+	return loadedPrompts, promptRegistryErr
+}
+
+// addFS parses every .tmpl file of a directory into the registry
+func (r *promptRegistry) addFS(fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.tmpl"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		jobType, version, ok := parsePromptFileName(path.Base(file))
+		if !ok {
+			log.Printf("Warning: Ignoring prompt file %s, expected <job_type>.<version>.tmpl", file)
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := template.New(path.Base(file)).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("invalid prompt template %s: %w", file, err)
+		}
+
+		if r.templates[jobType] == nil {
+			r.templates[jobType] = map[string]*promptTemplate{}
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:8])
+		if existing, ok := r.templates[jobType][version]; ok && existing.hash != hash {
+			log.Printf("📝 Prompt %s %s replaced by %s (hash %s, was %s)", jobType, version, file, hash, existing.hash)
+		}
+
+		r.templates[jobType][version] = &promptTemplate{
+			jobType: jobType,
+			version: version,
+			hash:    hash,
+			tmpl:    tmpl,
+		}
+	}
+
+	return nil
+}
+
+// parsePromptFileName splits "<job_type>.<version>.tmpl"
+func parsePromptFileName(name string) (jobType, version string, ok bool) {
+	parts := strings.Split(strings.TrimSuffix(name, ".tmpl"), ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// forUser returns the prompt version a user gets for a job type. By default
+// that is the latest version; AI_PROMPT_VERSION_<JOB_TYPE> pins one version
+// or, with a comma-separated list, splits users between versions (the same
+// user always gets the same one) to A/B test prompts.
+func (r *promptRegistry) forUser(jobType, userID string) (*promptTemplate, error) {
+	versions := r.templates[jobType]
+	if len(versions) == 0 {
+		return nil, permanentError(fmt.Errorf("no prompt template for %s", jobType))
+	}
+
+	candidates := []string{}
+	envKey := "AI_PROMPT_VERSION_" + strings.ToUpper(jobType)
+	for _, version := range strings.Split(os.Getenv(envKey), ",") {
+		if version = strings.TrimSpace(version); version == "" {
+			continue
+		}
+		if _, ok := versions[version]; !ok {
+			log.Printf("Warning: %s lists unknown prompt version %q", envKey, version)
+			continue
+		}
+		candidates = append(candidates, version)
+	}
+
+	if len(candidates) == 0 {
+		return versions[latestPromptVersion(versions)], nil
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return versions[candidates[h.Sum32()%uint32(len(candidates))]], nil
+}
+
+// latestPromptVersion returns the highest version, comparing "v<n>" numerically
+func latestPromptVersion(versions map[string]*promptTemplate) string {
+	names := make([]string, 0, len(versions))
+	for name := range versions {
+		names = append(names, name)
+	}
+
+	slices.SortFunc(names, func(a, b string) int {
+		na, errA := strconv.Atoi(strings.TrimPrefix(a, "v"))
+		nb, errB := strconv.Atoi(strings.TrimPrefix(b, "v"))
+		if errA == nil && errB == nil {
+			return na - nb
+		}
+		return strings.Compare(a, b)
+	})
+
+	return names[len(names)-1]
+}
+
+// renderPrompt renders the user's prompt version for a job type and returns
+// the prompt with the template it was rendered from
+func renderPrompt(app core.App, jobType, userID string, data any) (string, renderedPrompt, error) {
+	// The load error is kept until restart, so retrying the job can't help
+	registry, err := loadPromptRegistry(app)
+	if err != nil {
+		return "", renderedPrompt{}, permanentError(err)
+	}
+
+	prompt, err := registry.forUser(jobType, userID)
+	if err != nil {
+		return "", renderedPrompt{}, err
+	}
+
+	text, err := prompt.render(data)
+	if err != nil {
+		return "", renderedPrompt{}, err
+	}
+
+	return text, renderedPrompt{version: prompt.version, hash: prompt.hash}, nil
+}
+
+// set stores the prompt version and hash on an analysis or job record
+func (p renderedPrompt) set(record *core.Record) {
+	record.Set("prompt_version", p.version)
+	record.Set("prompt_hash", p.hash)
+}
+
+// embeddedPromptTokens estimates the fixed tokens of a job type's prompt from
+// its largest built-in version, for token estimates made before a job runs
+func embeddedPromptTokens(jobType string) int {
+	files, _ := fs.Glob(embeddedPrompts, "prompts/"+jobType+".*.tmpl")
+
+	longest := 0
+	for _, file := range files {
+		data, err := fs.ReadFile(embeddedPrompts, file)
+		if err == nil {
+			longest = max(longest, estimateTextTokens(string(data)))
+		}
+	}
+	return longest
+}
@@ -0,0 +1,171 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"strings"
+)
+
+// AIRequest is a single prompt sent to an AI provider
+type AIRequest struct {
+	Prompt          string
+	Temperature     float64
+	MaxOutputTokens int
+}
+
+// AIResponse is the generated output returned by an AI provider
+type AIResponse struct {
+	Text             string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// AIProvider is implemented by every model backend the queue processor can call
+type AIProvider interface {
+	// Name identifies the provider in logs
+	Name() string
+	// Generate sends the prompt to the model and returns its output
+	Generate(ctx context.Context, req AIRequest) (*AIResponse, error)
+}
+
+// AIProviderError describes a failed call to an AI provider
+type AIProviderError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+	Err        error // underlying transport error, never shown to users
+}
+
+func (e *AIProviderError) Error() string {
+	if e.StatusCode > 0 {
+		return fmt.Sprintf("%s: HTTP %d: %s", e.Provider, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Provider, e.Message)
+}
+
+func (e *AIProviderError) Unwrap() error { return e.Err }
+
+// Global AI provider used by the queue processor
+var aiProvider AIProvider
+
+// NewAIProviderFromEnv builds the AI provider selected by AI_PROVIDER.
+// Supported values are "aistudio" and "offline". When unset, AI Studio is
+// used if an API key is configured, otherwise the offline provider.
+func NewAIProviderFromEnv() (AIProvider, error) {
+	apiKey := os.Getenv("AI_STUDIO_API_KEY")
+	model := os.Getenv("AI_STUDIO_MODEL")
+
+	providerName := strings.ToLower(os.Getenv("AI_PROVIDER"))
+	if providerName == "" {
+		if apiKey != "" && apiKey != "your_api_key_here" {
+			providerName = "aistudio"
+		} else {
+			providerName = "offline"
+		}
+	}
+
+	switch providerName {
+	case "aistudio":
+		if apiKey == "" {
+			return nil, fmt.Errorf("AI_STUDIO_API_KEY is required for the aistudio provider")
+		}
+		return NewAIStudioProvider(apiKey, model), nil
+	case "offline":
+		return NewOfflineProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown AI_PROVIDER: %s", providerName)
+	}
+}
+
+// OfflineProvider is a deterministic stand-in model for development and CI.
+// It never touches the network and always returns the same output for the
+// same prompt.
+type OfflineProvider struct{}
+
+// NewOfflineProvider creates a new offline provider
+func NewOfflineProvider() *OfflineProvider {
+	return &OfflineProvider{}
+}
+
+// Name returns the provider name
+func (p *OfflineProvider) Name() string {
+	return "offline"
+}
+
+// Generate returns a canned JSON analysis derived from a hash of the prompt.
+// The output carries every field requested by the analysis prompts so it can
+// be parsed by any job type.
+func (p *OfflineProvider) Generate(ctx context.Context, req AIRequest) (*AIResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(req.Prompt))
+	seed := h.Sum32()
+
+	tones := []string{"positive", "neutral", "negative"}
+	trends := []string{"improving", "stable", "declining"}
+	themes := []string{"work", "family", "health", "learning", "relationships", "rest", "creativity"}
+	actions := []string{
+		"Take a short walk after lunch",
+		"Write down three things you are grateful for",
+		"Schedule some uninterrupted downtime",
+		"Reach out to a friend this week",
+		"Set one small, concrete goal for tomorrow",
+	}
+	quotes := []string{
+		"Progress, not perfection.",
+		"Small steps every day add up to big changes.",
+		"You are allowed to be both a masterpiece and a work in progress.",
+	}
+
+	pick := func(list []string, offset uint32) string {
+		return list[(seed+offset)%uint32(len(list))]
+	}
+
+	firstTheme := pick(themes, 0)
+	secondTheme := pick(themes, 3)
+	if secondTheme == firstTheme {
+		secondTheme = pick(themes, 4)
+	}
+
+	text := fmt.Sprintf(`{
+  "tone": %q,
+  "themes": [%q, %q],
+  "key_themes": [%q, %q],
+  "growth_indicators": ["Reflected on %s"],
+  "insight": "You are building awareness around %s.",
+  "growth_score": %d,
+  "emotional_trend": %q,
+  "action_items": [%q, %q, %q],
+  "motivation_quote": %q
+}`,
+		pick(tones, 0),
+		firstTheme, secondTheme,
+		firstTheme, secondTheme,
+		firstTheme,
+		secondTheme,
+		40+int(seed%61),
+		pick(trends, 1),
+		pick(actions, 0), pick(actions, 2), pick(actions, 4),
+		pick(quotes, 0),
+	)
+
+	log.Printf("🧪 Offline provider generated response (%d chars)", len(text))
+
+	return &AIResponse{
+		Text:             text,
+		PromptTokens:     estimateTextTokens(req.Prompt),
+		CompletionTokens: estimateTextTokens(text),
+	}, nil
+}
+
+// estimateTextTokens roughly estimates the token count of a text
+// (about 4 characters per token)
+func estimateTextTokens(text string) int {
+	return (len(text) + 3) / 4
+}
@@ -0,0 +1,142 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Highest job priority; aged jobs are capped here
+const maxJobPriority = 10
+
+// priorityAgingInterval returns the wait after which a job gains one priority
+// level (AI_QUEUE_PRIORITY_AGING_MINUTES, 0 disables aging)
+func priorityAgingInterval() time.Duration {
+	return time.Duration(getEnvFloat("AI_QUEUE_PRIORITY_AGING_MINUTES", 10) * float64(time.Minute))
+}
+
+// effectivePriorityExpr returns the SQL expression for a job's priority after
+// aging. It expects the {:now} param.
+func effectivePriorityExpr() string {
+	interval := priorityAgingInterval()
+	if interval <= 0 {
+		return "priority"
+	}
+
+	return fmt.Sprintf(
+		"MIN(%d, priority + CAST(MAX(julianday({:now}) - julianday(scheduled_at), 0) * 86400 / %d AS INTEGER))",
+		maxJobPriority,
+		int64(interval/time.Second),
+	)
+}
+
+// effectivePriority is the Go counterpart of effectivePriorityExpr: the
+// priority rises by one level per aging interval spent waiting
+func effectivePriority(job *core.Record, now time.Time) int {
+	priority := job.GetInt("priority")
+
+	interval := priorityAgingInterval()
+	if interval <= 0 {
+		return priority
+	}
+
+	if waited := now.Sub(job.GetDateTime("scheduled_at").Time()); waited > 0 {
+		priority += int(waited / interval)
+	}
+
+	return min(priority, maxJobPriority)
+}
+
+// queueMaxWait returns the optional max wait of a job type
+// (AI_QUEUE_MAX_WAIT_<JOB_TYPE>, e.g. AI_QUEUE_MAX_WAIT_ENTRY_ANALYSIS=30m)
+func queueMaxWait(jobType string) time.Duration {
+	envKey := "AI_QUEUE_MAX_WAIT_" + strings.ToUpper(jobType)
+	raw := os.Getenv(envKey)
+	if raw == "" {
+		return 0
+	}
+
+	maxWait, err := time.ParseDuration(raw)
+	if err != nil {
+		log.Printf("Warning: Invalid %s %q: %v", envKey, raw, err)
+		return 0
+	}
+	return maxWait
+}
+
+// startQueueSLAMonitor checks every minute for jobs waiting longer than the
+// max wait of their job type
+func startQueueSLAMonitor(app core.App) {
+	monitored := 0
+	for jobType := range retryPolicies {
+		if maxWait := queueMaxWait(jobType); maxWait > 0 {
+			log.Printf("⏱️  %s jobs have a max wait of %v", jobType, maxWait)
+			monitored++
+		}
+	}
+
+	if monitored == 0 {
+		return
+	}
+
+	app.Cron().MustAdd("aiQueueSLA", "* * * * *", func() {
+		checkQueueSLAs(app, time.Now())
+	})
+}
+
+// checkQueueSLAs raises a warning event (in the app logs) once for every
+// pending job that waited longer than its max wait
+func checkQueueSLAs(app core.App, now time.Time) {
+	for jobType := range retryPolicies {
+		maxWait := queueMaxWait(jobType)
+		if maxWait <= 0 {
+			continue
+		}
+
+		jobs, err := app.FindRecordsByFilter(
+			"ai_processing_queue",
+			"job_type = {:jobType} && status = 'pending' && scheduled_at < {:deadline} && sla_warned_at = ''",
+			"scheduled_at",
+			100,
+			0,
+			map[string]any{
+				"jobType":  jobType,
+				"deadline": now.Add(-maxWait).UTC().Format(types.DefaultDateLayout),
+			},
+		)
+		if err != nil {
+			log.Printf("Error checking %s max wait: %v", jobType, err)
+			continue
+		}
+
+		for _, job := range jobs {
+			waited := now.Sub(job.GetDateTime("scheduled_at").Time()).Round(time.Second)
+
+			app.Logger().Warn(
+				"AI job exceeded its max wait",
+				"jobId", job.Id,
+				"jobType", jobType,
+				"user", job.GetString("user"),
+				"priority", job.GetInt("priority"),
+				"effectivePriority", effectivePriority(job, now),
+				"waited", waited.String(),
+				"maxWait", maxWait.String(),
+			)
+			log.Printf("⚠️  Job %s (%s) waited %v, over its max wait of %v", job.Id, jobType, waited, maxWait)
+
+			// Warn only once per job (without touching the rest of the record)
+			_, err := app.DB().NewQuery("UPDATE ai_processing_queue SET sla_warned_at = {:now} WHERE id = {:id}").
+				Bind(dbx.Params{"id": job.Id, "now": now.UTC().Format(types.DefaultDateLayout)}).
+				Execute()
+			if err != nil {
+				log.Printf("Warning: Failed to mark SLA warning for job %s: %v", job.Id, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// processorID identifies this processor instance in job leases
+var processorID = newProcessorID()
+
+// newProcessorID builds a unique id from the host name and process id
+func newProcessorID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), security.RandomString(6))
+}
+
+// Shortest lease AI_QUEUE_LEASE_SECONDS may set. The heartbeat renews the
+// lease every third of it, so it must leave room for a few slow writes.
+const minQueueLease = 10 * time.Second
+
+// queueLeaseDuration returns how long a claimed job stays locked without a heartbeat
+func queueLeaseDuration() time.Duration {
+	seconds := getEnvFloat("AI_QUEUE_LEASE_SECONDS", 120)
+	if !(seconds >= minQueueLease.Seconds()) { // also catches NaN
+		return minQueueLease
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// findClaimableJobs returns jobs that are due, plus processing jobs whose
+// lease ran out, ordered by priority after aging (desc) and scheduled_at
+// (asc). At most perUser jobs are returned for each user, so one user's
+// backlog can't fill the batch.
+func findClaimableJobs(app core.App, limit, perUser int) ([]*core.Record, error) {
+	var ids []string
+	err := app.DB().NewQuery(`
+		SELECT id FROM (
+			SELECT id, effective_priority, scheduled_at, ROW_NUMBER() OVER (
+				PARTITION BY user ORDER BY effective_priority DESC, scheduled_at
+			) AS user_rank
+			FROM (
+				SELECT id, user, scheduled_at, ` + effectivePriorityExpr() + ` AS effective_priority
+				FROM ai_processing_queue
+				WHERE (status = 'pending' AND scheduled_at <= {:now})
+					OR (status = 'processing' AND lease_expires_at < {:now})
+			)
+		)
+		WHERE user_rank <= {:perUser}
+		ORDER BY effective_priority DESC, scheduled_at
+		LIMIT {:limit}
+	`).Bind(dbx.Params{
+		"now":     time.Now().UTC().Format(types.DefaultDateLayout),
+		"perUser": perUser,
+		"limit":   limit,
+	}).Column(&ids)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	records, err := app.FindRecordsByIds("ai_processing_queue", ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the query order
+	byID := make(map[string]*core.Record, len(records))
+	for _, record := range records {
+		byID[record.Id] = record
+	}
+	jobs := make([]*core.Record, 0, len(records))
+	for _, id := range ids {
+		if record, ok := byID[id]; ok {
+			jobs = append(jobs, record)
+		}
+	}
+
+	return jobs, nil
+}
+
+// claimJob atomically takes the lease on a job and counts the attempt. It
+// returns nil when another processor claimed the job first.
+func claimJob(app core.App, jobID string) (*core.Record, error) {
+	var claimed *core.Record
+
+	err := app.RunInTransaction(func(txApp core.App) error {
+		now := time.Now().UTC()
+
+		result, err := txApp.DB().NewQuery(`
+			UPDATE ai_processing_queue
+			SET status = 'processing',
+				attempts = attempts + 1,
+				locked_by = {:owner},
+				lease_expires_at = {:lease},
+				heartbeat_at = {:now},
+				started_at = {:now}
+			WHERE id = {:id}
+				AND ((status = 'pending' AND scheduled_at <= {:now})
+					OR (status = 'processing' AND lease_expires_at < {:now}))
+		`).Bind(dbx.Params{
+			"id":    jobID,
+			"owner": processorID,
+			"now":   now.Format(types.DefaultDateLayout),
+			"lease": now.Add(queueLeaseDuration()).Format(types.DefaultDateLayout),
+		}).Execute()
+		if err != nil {
+			return err
+		}
+
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			return nil
+		}
+
+		claimed, err = txApp.FindRecordById("ai_processing_queue", jobID)
+		return err
+	})
+
+	if claimed != nil {
+		notifyJobStatus(app, claimed)
+	}
+
+	return claimed, err
+}
+
+// startJobHeartbeat extends the job lease until the returned stop func is called
+func startJobHeartbeat(app core.App, job *core.Record) (stop func()) {
+	done := make(chan struct{})
+	interval := queueLeaseDuration() / 3
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if !renewJobLease(app, job) {
+					log.Printf("⚠️  Lost lease on job %s", job.Id)
+					return
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// renewJobLease extends the lease if this processor still holds it
+func renewJobLease(app core.App, job *core.Record) bool {
+	now := time.Now().UTC()
+
+	result, err := app.DB().NewQuery(`
+		UPDATE ai_processing_queue
+		SET lease_expires_at = {:lease}, heartbeat_at = {:now}
+		WHERE id = {:id} AND status = 'processing' AND locked_by = {:owner}
+	`).Bind(dbx.Params{
+		"id":    job.Id,
+		"owner": processorID,
+		"now":   now.Format(types.DefaultDateLayout),
+		"lease": now.Add(queueLeaseDuration()).Format(types.DefaultDateLayout),
+	}).Execute()
+	if err != nil {
+		log.Printf("Warning: Failed to renew lease on job %s: %v", job.Id, err)
+		return true // transient, try again on the next beat
+	}
+
+	affected, _ := result.RowsAffected()
+	return affected > 0
+}
+
+// ownsJobLease reports whether this processor still holds the job lease
+func ownsJobLease(app core.App, job *core.Record) bool {
+	fresh, err := app.FindRecordById("ai_processing_queue", job.Id)
+	if err != nil {
+		return false
+	}
+	return fresh.GetString("status") == "processing" && fresh.GetString("locked_by") == processorID
+}
+
+// clearJobLease removes the lease fields before a job leaves the processing state
+func clearJobLease(job *core.Record) {
+	job.Set("locked_by", "")
+	job.Set("lease_expires_at", "")
+}
+
+// releaseHeldJobs puts every job leased by this processor back to pending.
+// The interrupted attempt is not counted. Jobs are released one at a time so
+// a job that fails to release doesn't keep the others leased.
+func releaseHeldJobs(app core.App) (int64, error) {
+	held, err := app.FindRecordsByFilter(
+		"ai_processing_queue",
+		"status = 'processing' && locked_by = {:owner}",
+		"", 0, 0,
+		dbx.Params{"owner": processorID},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var released int64
+	var errs []error
+	for _, job := range held {
+		ok, err := releaseHeldJob(app, job)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("job %s: %w", job.Id, err))
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+
+	return released, errors.Join(errs...)
+}
+
+// releaseHeldJob puts one leased job back to pending. A job superseded by a
+// newer waiting job with the same dedupe_key is cancelled instead. It reports
+// whether the job went back to pending.
+func releaseHeldJob(app core.App, job *core.Record) (bool, error) {
+	if cancelled, err := cancelIfSuperseded(app, job); err != nil || cancelled {
+		return false, err
+	}
+
+	result, err := app.DB().NewQuery(`
+		UPDATE ai_processing_queue
+		SET status = 'pending', locked_by = '', lease_expires_at = '',
+			attempts = MAX(attempts - 1, 0)
+		WHERE id = {:id} AND status = 'processing' AND locked_by = {:owner}
+	`).Bind(dbx.Params{"id": job.Id, "owner": processorID}).Execute()
+	if err != nil {
+		if isUniqueConstraintError(err) {
+			// A newer job with the same key was queued since the check above
+			return false, cancelJobs(app, []string{job.Id}, supersededJobReason)
+		}
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func TestReleaseHeldJobs(t *testing.T) {
+	app, err := tests.NewTestApp(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Cleanup()
+
+	claim := func(email string, edited bool) *core.Record {
+		user, entry := createTestEntry(t, app, email)
+		enqueueEntryAnalysis(t, app, user.Id, entry.Id)
+
+		pending, err := app.FindFirstRecordByData("ai_processing_queue", "entry_id", entry.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job, err := claimJob(app, pending.Id)
+		if err != nil || job == nil {
+			t.Fatalf("Expected the job to be claimed, got %v", err)
+		}
+
+		if edited {
+			enqueueEntryAnalysis(t, app, user.Id, entry.Id)
+		}
+		return job
+	}
+
+	// The superseded job sits between the others, so a failure to release it
+	// would show up as the last job still being held
+	jobs := []*core.Record{
+		claim("first@example.com", false),
+		claim("edited@example.com", true),
+		claim("last@example.com", false),
+	}
+	expected := []string{"pending", "cancelled", "pending"}
+
+	released, err := releaseHeldJobs(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if released != 2 {
+		t.Errorf("Expected 2 released jobs, got %d", released)
+	}
+
+	for i, job := range jobs {
+		job, err := app.FindRecordById("ai_processing_queue", job.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := job.GetString("status"); status != expected[i] {
+			t.Errorf("Expected job %d to be %s, got %s", i, expected[i], status)
+		}
+		if job.GetString("locked_by") != "" {
+			t.Errorf("Expected job %d to have no lease, got %q", i, job.GetString("locked_by"))
+		}
+		if expected[i] == "pending" && job.GetInt("attempts") != 0 {
+			t.Errorf("Expected the interrupted attempt of job %d not to count, got %d attempts", i, job.GetInt("attempts"))
+		}
+	}
+}
+
+func TestQueueLeaseDuration(t *testing.T) {
+	scenarios := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 120 * time.Second},
+		{"300", 300 * time.Second},
+		{"10", 10 * time.Second},
+		{"1.5", minQueueLease},
+		{"0", minQueueLease},
+		{"-60", minQueueLease},
+		{"NaN", minQueueLease},
+		{"soon", 120 * time.Second},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.value, func(t *testing.T) {
+			t.Setenv("AI_QUEUE_LEASE_SECONDS", s.value)
+
+			if result := queueLeaseDuration(); result != s.expected {
+				t.Errorf("Expected %v, got %v", s.expected, result)
+			}
+		})
+	}
+}
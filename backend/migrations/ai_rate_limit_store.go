@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// refilledTokensExpr is the bucket balance refilled up to now (SQL)
+const refilledTokensExpr = "MIN({:capacity}, tokens + MAX({:now} - updated_unix, 0) * {:rate})"
+
+// NewSharedTokenBucket creates a token bucket whose balance is stored in the
+// ai_rate_limits collection, so all processors using the same database share
+// one budget. A new bucket starts full.
+func NewSharedTokenBucket(app core.App, name string, capacity, rate float64) (*TokenBucket, error) {
+	_, err := app.DB().NewQuery(`
+		INSERT OR IGNORE INTO ai_rate_limits (id, name, tokens, updated_unix)
+		VALUES ({:id}, {:name}, {:tokens}, {:now})
+	`).Bind(dbx.Params{
+		"id":     core.GenerateDefaultRandomId(),
+		"name":   name,
+		"tokens": capacity,
+		"now":    unixSeconds(time.Now()),
+	}).Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenBucket{
+		capacity: capacity,
+		rate:     rate,
+		store:    &sqliteBucketStore{app: app, name: name},
+	}, nil
+}
+
+// sqliteBucketStore keeps the balance in a row of ai_rate_limits. Every
+// change is a single UPDATE, so concurrent processors can't overspend.
+type sqliteBucketStore struct {
+	app  core.App
+	name string
+}
+
+func (s *sqliteBucketStore) apply(capacity, rate, delta float64, strict bool, now time.Time) (bool, float64, error) {
+	params := dbx.Params{
+		"name":     s.name,
+		"capacity": capacity,
+		"rate":     rate,
+		"delta":    delta,
+		"now":      unixSeconds(now),
+	}
+
+	where := "name = {:name}"
+	if strict {
+		where += " AND " + refilledTokensExpr + " + {:delta} >= 0"
+	}
+
+	var balance float64
+	err := s.app.DB().NewQuery(`
+		UPDATE ai_rate_limits
+		SET tokens = MIN({:capacity}, ` + refilledTokensExpr + ` + {:delta}),
+			updated_unix = MAX(updated_unix, {:now})
+		WHERE ` + where + `
+		RETURNING tokens
+	`).Bind(params).Row(&balance)
+	if err == nil {
+		return true, balance, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+
+	// Not enough tokens: report the current balance
+	err = s.app.DB().NewQuery(`
+		SELECT ` + refilledTokensExpr + ` FROM ai_rate_limits WHERE name = {:name}
+	`).Bind(params).Row(&balance)
+	if err != nil {
+		return false, 0, err
+	}
+
+	return false, balance, nil
+}
+
+// unixSeconds returns t as fractional Unix seconds
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,129 @@
+package migrations
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func TestSqliteBucketStoreApply(t *testing.T) {
+	app, err := tests.NewTestApp(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Cleanup()
+
+	if _, err := NewSharedTokenBucket(app, "apply", 100, 10); err != nil {
+		t.Fatal(err)
+	}
+	store := &sqliteBucketStore{app: app, name: "apply"}
+
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Same cases as the memory store, the balance is set on the row first
+	scenarios := []struct {
+		name     string
+		tokens   float64
+		elapsed  time.Duration
+		delta    float64
+		strict   bool
+		applied  bool
+		expected float64
+	}{
+		{"consume from a full bucket", 100, 0, -40, true, true, 60},
+		{"refill for the elapsed time", 20, 3 * time.Second, -10, true, true, 40},
+		{"refill is capped at capacity", 90, time.Minute, 0, true, true, 100},
+		{"strict rejects going below zero", 10, 0, -20, true, false, 10},
+		{"non-strict goes into debt", 10, 0, -30, false, true, -20},
+		{"debt is refilled over time", -20, 2 * time.Second, 0, false, true, 0},
+		{"returned tokens are capped at capacity", 95, 0, 50, false, true, 100},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			_, err := app.DB().NewQuery(`
+				UPDATE ai_rate_limits SET tokens = {:tokens}, updated_unix = {:at} WHERE name = 'apply'
+			`).Bind(dbx.Params{"tokens": s.tokens, "at": unixSeconds(start)}).Execute()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			applied, balance, err := store.apply(100, 10, s.delta, s.strict, start.Add(s.elapsed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if applied != s.applied {
+				t.Errorf("Expected applied %v, got %v", s.applied, applied)
+			}
+			if math.Abs(balance-s.expected) > 1e-6 {
+				t.Errorf("Expected balance %.0f, got %.2f", s.expected, balance)
+			}
+		})
+	}
+}
+
+func TestSharedTokenBucket(t *testing.T) {
+	app, err := tests.NewTestApp(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Cleanup()
+
+	t.Run("reserve goes into debt", func(t *testing.T) {
+		bucket, err := NewSharedTokenBucket(app, "reserve", 100, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if delay, err := bucket.Reserve(100); err != nil || delay != 0 {
+			t.Fatalf("Expected no delay, got %v (%v)", delay, err)
+		}
+
+		delay, err := bucket.Reserve(50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(delay.Seconds()-5) > 0.1 {
+			t.Errorf("Expected the reservation to wait about 5s, got %v", delay)
+		}
+		if available, _ := bucket.Available(); math.Abs(available+50) > 1 {
+			t.Errorf("Expected a debt of about 50 tokens, got %.2f", available)
+		}
+	})
+
+	t.Run("buckets with the same name share the balance", func(t *testing.T) {
+		first, err := NewSharedTokenBucket(app, "shared", 100, 0.01)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !first.Consume(60) {
+			t.Fatal("Expected the first bucket to consume 60 tokens")
+		}
+
+		// A second processor doesn't reset the existing row
+		second, err := NewSharedTokenBucket(app, "shared", 100, 0.01)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available, _ := second.Available(); math.Abs(available-40) > 1 {
+			t.Errorf("Expected the second bucket to see about 40 tokens, got %.2f", available)
+		}
+
+		// Strict consumes can't overspend the shared balance
+		if second.Consume(50) {
+			t.Error("Expected the second bucket to reject 50 tokens")
+		}
+		if !second.Consume(40) {
+			t.Error("Expected the second bucket to consume 40 tokens")
+		}
+		if available, _ := first.Available(); math.Abs(available) > 1 {
+			t.Errorf("Expected the first bucket to see about 0 tokens, got %.2f", available)
+		}
+		if first.Consume(10) {
+			t.Error("Expected the first bucket to reject 10 tokens")
+		}
+	})
+}
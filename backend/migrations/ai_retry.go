@@ -0,0 +1,207 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// retryPolicy controls how often and how fast a failed job is retried
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	jitter      float64 // fraction of the delay randomized in both directions
+}
+
+// Default retry policies per job type. Each can be overridden with
+// AI_RETRY_<JOB_TYPE>="maxAttempts,baseDelay,maxDelay,jitter",
+// e.g. AI_RETRY_ENTRY_ANALYSIS="5,30s,30m,0.2"
+var retryPolicies = map[string]retryPolicy{
+	"entry_analysis":     {maxAttempts: 5, baseDelay: 30 * time.Second, maxDelay: 30 * time.Minute, jitter: 0.2},
+	"daily_summary":      {maxAttempts: 4, baseDelay: time.Minute, maxDelay: time.Hour, jitter: 0.2},
+	"weekly_analysis":    {maxAttempts: 5, baseDelay: 2 * time.Minute, maxDelay: 2 * time.Hour, jitter: 0.2},
+	"monthly_analysis":   {maxAttempts: 5, baseDelay: 5 * time.Minute, maxDelay: 6 * time.Hour, jitter: 0.2},
+	"streak_update":      {maxAttempts: 3, baseDelay: 10 * time.Second, maxDelay: 5 * time.Minute, jitter: 0.2},
+	"growth_calculation": {maxAttempts: 3, baseDelay: time.Minute, maxDelay: time.Hour, jitter: 0.2},
+}
+
+// Policy for job types without an entry above
+var defaultRetryPolicy = retryPolicy{maxAttempts: 3, baseDelay: time.Minute, maxDelay: 30 * time.Minute, jitter: 0.2}
+
+// retryPolicyFor returns the policy of a job type, applying env overrides
+func retryPolicyFor(jobType string) retryPolicy {
+	policy, ok := retryPolicies[jobType]
+	if !ok {
+		policy = defaultRetryPolicy
+	}
+
+	envKey := "AI_RETRY_" + strings.ToUpper(jobType)
+	if raw := os.Getenv(envKey); raw != "" {
+		override, err := parseRetryPolicy(raw, policy)
+		if err != nil {
+			log.Printf("Warning: Invalid %s %q, using defaults: %v", envKey, raw, err)
+		} else {
+			policy = override
+		}
+	}
+
+	return policy
+}
+
+// parseRetryPolicy parses "maxAttempts,baseDelay,maxDelay,jitter". Missing
+// trailing values keep the given defaults.
+func parseRetryPolicy(raw string, policy retryPolicy) (retryPolicy, error) {
+	parts := strings.Split(raw, ",")
+	if len(parts) > 4 {
+		return policy, errors.New("expected maxAttempts,baseDelay,maxDelay,jitter")
+	}
+
+	var err error
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		switch i {
+		case 0:
+			policy.maxAttempts, err = strconv.Atoi(part)
+		case 1:
+			policy.baseDelay, err = time.ParseDuration(part)
+		case 2:
+			policy.maxDelay, err = time.ParseDuration(part)
+		case 3:
+			policy.jitter, err = strconv.ParseFloat(part, 64)
+		}
+		if err != nil {
+			return policy, err
+		}
+	}
+
+	if policy.maxAttempts < 1 || policy.baseDelay <= 0 || policy.maxDelay < policy.baseDelay || policy.jitter < 0 || policy.jitter > 1 {
+		return policy, errors.New("values out of range")
+	}
+
+	return policy, nil
+}
+
+// backoff returns the delay before the next attempt, doubling from baseDelay
+// up to maxDelay, randomized by ±jitter
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.baseDelay) * math.Pow(2, float64(max(attempt-1, 0)))
+	if delay > float64(p.maxDelay) {
+		delay = float64(p.maxDelay)
+	}
+
+	if p.jitter > 0 {
+		delay *= 1 - p.jitter + rand.Float64()*2*p.jitter
+	}
+
+	return time.Duration(delay)
+}
+
+// permanentJobError marks an error that retrying can't fix
+type permanentJobError struct {
+	err error
+}
+
+func (e *permanentJobError) Error() string { return e.err.Error() }
+func (e *permanentJobError) Unwrap() error { return e.err }
+
+// permanentError wraps err so the job fails without retries
+func permanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentJobError{err: err}
+}
+
+// isTransientError reports whether a failed job may succeed when retried.
+// Rate limits (429), provider errors (5xx), timeouts and network errors are
+// transient; other provider rejections (bad prompt, 4xx) and errors marked
+// with permanentError are not.
+func isTransientError(err error) bool {
+	var permanent *permanentJobError
+	if errors.As(err, &permanent) {
+		return false
+	}
+
+	var providerErr *AIProviderError
+	if errors.As(err, &providerErr) {
+		switch code := providerErr.StatusCode; {
+		case code == http.StatusTooManyRequests, code == http.StatusRequestTimeout:
+			return true
+		case code >= 400 && code < 500:
+			return false
+		}
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	return true
+}
+
+// retryOrFailJob reschedules a failed job with backoff, or fails it when the
+// error is permanent or the job ran out of attempts
+func retryOrFailJob(app core.App, job *core.Record, jobErr error) error {
+	policy := retryPolicyFor(job.GetString("job_type"))
+	attempts := job.GetInt("attempts")
+
+	if !isTransientError(jobErr) {
+		return markJobFailed(app, job, jobErr.Error())
+	}
+
+	if attempts >= policy.maxAttempts {
+		return markJobFailed(app, job, fmt.Sprintf("giving up after %d attempts: %v", attempts, jobErr))
+	}
+
+	if cancelled, err := cancelIfSuperseded(app, job); err != nil || cancelled {
+		return err
+	}
+
+	delay := policy.backoff(attempts)
+
+	appendJobError(job, jobErr.Error())
+
+	job.Set("status", "pending")
+	job.Set("error_message", jobErr.Error())
+	job.Set("scheduled_at", time.Now().Add(delay).UTC())
+	clearJobLease(job)
+	if err := app.Save(job); err != nil {
+		return err
+	}
+
+	log.Printf("🔁 Job %s attempt %d/%d failed, retrying in %v: %v", job.Id, attempts, policy.maxAttempts, delay.Round(time.Second), jobErr)
+	return nil
+}
+
+// deferJob puts a job back to pending for later without counting the attempt
+func deferJob(app core.App, job *core.Record, delay time.Duration, reason string) error {
+	if cancelled, err := cancelIfSuperseded(app, job); err != nil || cancelled {
+		return err
+	}
+
+	job.Set("status", "pending")
+	job.Set("attempts", max(job.GetInt("attempts")-1, 0))
+	job.Set("scheduled_at", time.Now().Add(delay).UTC())
+	clearJobLease(job)
+	if err := app.Save(job); err != nil {
+		return err
+	}
+
+	log.Printf("⏸️  Job %s deferred by %v: %s", job.Id, delay, reason)
+	return nil
+}
@@ -0,0 +1,128 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	defaults := retryPolicy{maxAttempts: 3, baseDelay: time.Minute, maxDelay: 30 * time.Minute, jitter: 0.2}
+
+	scenarios := []struct {
+		name      string
+		raw       string
+		expected  retryPolicy
+		expectErr bool
+	}{
+		{
+			name:     "all values",
+			raw:      "5,30s,1h,0.1",
+			expected: retryPolicy{maxAttempts: 5, baseDelay: 30 * time.Second, maxDelay: time.Hour, jitter: 0.1},
+		},
+		{
+			name:     "missing trailing values keep the defaults",
+			raw:      "7",
+			expected: retryPolicy{maxAttempts: 7, baseDelay: time.Minute, maxDelay: 30 * time.Minute, jitter: 0.2},
+		},
+		{
+			name:     "empty values keep the defaults",
+			raw:      " , 10s , , 0",
+			expected: retryPolicy{maxAttempts: 3, baseDelay: 10 * time.Second, maxDelay: 30 * time.Minute, jitter: 0},
+		},
+		{"too many values", "1,1s,1m,0.1,extra", defaults, true},
+		{"invalid attempts", "many", defaults, true},
+		{"invalid duration", "3,soon", defaults, true},
+		{"zero attempts", "0", defaults, true},
+		{"max delay below base delay", "3,1h,1m", defaults, true},
+		{"jitter above 1", "3,1s,1m,1.5", defaults, true},
+		{"negative jitter", "3,1s,1m,-0.1", defaults, true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			result, err := parseRetryPolicy(s.raw, defaults)
+			if s.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != s.expected {
+				t.Errorf("Expected %+v, got %+v", s.expected, result)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 10, baseDelay: time.Second, maxDelay: 30 * time.Second}
+
+	scenarios := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{5, 16 * time.Second},
+		{6, 30 * time.Second},
+		{40, 30 * time.Second},
+	}
+
+	for _, s := range scenarios {
+		t.Run(fmt.Sprintf("attempt %d", s.attempt), func(t *testing.T) {
+			if result := policy.backoff(s.attempt); result != s.expected {
+				t.Errorf("Expected %v, got %v", s.expected, result)
+			}
+		})
+	}
+
+	t.Run("jitter stays within bounds", func(t *testing.T) {
+		jittered := policy
+		jittered.jitter = 0.2
+
+		for i := 0; i < 1000; i++ {
+			result := jittered.backoff(3)
+			if result < 3200*time.Millisecond || result > 4800*time.Millisecond {
+				t.Fatalf("Expected 4s ±20%%, got %v", result)
+			}
+		}
+	})
+}
+
+func TestIsTransientError(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"rate limited", &AIProviderError{StatusCode: 429}, true},
+		{"request timeout", &AIProviderError{StatusCode: 408}, true},
+		{"server error", &AIProviderError{StatusCode: 503}, true},
+		{"bad request", &AIProviderError{StatusCode: 400}, false},
+		{"forbidden", &AIProviderError{StatusCode: 403}, false},
+		{"no status (response without candidates)", &AIProviderError{Message: "no candidates returned"}, true},
+		{"wrapped provider error", fmt.Errorf("analysis: %w", &AIProviderError{StatusCode: 404}), false},
+		{"transport timeout", transportError("test", &url.Error{Op: "Post", URL: "https://example.com", Err: context.DeadlineExceeded}), true},
+		{"cancelled", transportError("test", &url.Error{Op: "Post", URL: "https://example.com", Err: context.Canceled}), false},
+		{"permanent", permanentError(errors.New("entry not found")), false},
+		{"permanent provider error", permanentError(&AIProviderError{StatusCode: 503}), false},
+		{"plain error", errors.New("invalid entry analysis JSON"), true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if result := isTransientError(s.err); result != s.expected {
+				t.Errorf("Expected %v, got %v", s.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package migrations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Maximum number of related entries stored on a growth_analysis record
+const maxRelatedEntries = 100
+
+// rollupSpec describes one level of hierarchical summarization
+type rollupSpec struct {
+	analysisType string // growth_analysis type produced by the job
+	sourceType   string // growth_analysis type summarized by the job
+	promptType   string // prompt template used for the summary
+}
+
+var (
+	weeklyRollup  = rollupSpec{analysisType: "weekly", sourceType: "entry", promptType: "weekly_analysis"}
+	monthlyRollup = rollupSpec{analysisType: "monthly", sourceType: "weekly", promptType: "monthly_analysis"}
+)
+
+// rollupAnalysisResult is the structured output expected from the model
+type rollupAnalysisResult struct {
+	GrowthScore     float64  `json:"growth_score"`
+	EmotionalTrend  string   `json:"emotional_trend"`
+	KeyThemes       []string `json:"key_themes"`
+	ActionItems     []string `json:"action_items"`
+	MotivationQuote string   `json:"motivation_quote"`
+}
+
+// processWeeklyAnalysis summarizes the week's entry-level analyses
+func processWeeklyAnalysis(ctx context.Context, app core.App, job *core.Record) error {
+	log.Printf("📈 Processing weekly analysis for job %s", job.Id)
+	return processRollupAnalysis(ctx, app, job, weeklyRollup)
+}
+
+// processMonthlyAnalysis summarizes the month's weekly summaries
+func processMonthlyAnalysis(ctx context.Context, app core.App, job *core.Record) error {
+	log.Printf("📉 Processing monthly analysis for job %s", job.Id)
+	return processRollupAnalysis(ctx, app, job, monthlyRollup)
+}
+
+// Time a monthly job waits for the weekly jobs of its month before it runs again
+const weeklyRollupWait = 15 * time.Minute
+
+// hasUnfinishedWeeklyJobs reports whether a weekly_analysis job overlapping a
+// monthly job's period is still pending or processing. The monthly summary
+// would otherwise miss (or use the stale version of) that week.
+func hasUnfinishedWeeklyJobs(app core.App, job *core.Record) (bool, error) {
+	weekly, err := app.FindRecordsByFilter(
+		"ai_processing_queue",
+		"user = {:userId} && job_type = 'weekly_analysis' && (status = 'pending' || status = 'processing') && period_start <= {:end} && period_end >= {:start}",
+		"",
+		1,
+		0,
+		map[string]any{
+			"userId": job.GetString("user"),
+			"start":  job.GetDateTime("period_start").String(),
+			"end":    job.GetDateTime("period_end").String(),
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return len(weekly) > 0, nil
+}
+
+// processRollupAnalysis summarizes the lower-level analyses of a period into
+// a single growth_analysis record
+func processRollupAnalysis(ctx context.Context, app core.App, job *core.Record, spec rollupSpec) error {
+	userID := job.GetString("user")
+	periodStart := job.GetDateTime("period_start").Time()
+	periodEnd := job.GetDateTime("period_end").Time()
+	if periodStart.IsZero() || periodEnd.IsZero() {
+		return permanentError(fmt.Errorf("job %s has no analysis period", job.Id))
+	}
+
+	// 1. Fetch the lower-level analyses overlapping the period
+	sources, err := findAnalysesInPeriod(app, userID, spec.sourceType, periodStart, periodEnd)
+	if err != nil {
+		return err
+	}
+
+	if len(sources) == 0 {
+		log.Printf("ℹ️  No %s analyses for user %s in %s - %s, skipping", spec.sourceType, userID, periodStart.Format("2006-01-02"), periodEnd.Format("2006-01-02"))
+		return nil
+	}
+
+	// 2. Build the prompt from aggregated metrics only (no raw content)
+	var summaries strings.Builder
+	relatedEntries := []string{}
+	seen := map[string]bool{}
+	for _, source := range sources {
+		summaries.WriteString(formatAnalysisSummary(source))
+		summaries.WriteString("\n")
+
+		for _, entryID := range source.GetStringSlice("related_entries") {
+			if !seen[entryID] && len(relatedEntries) < maxRelatedEntries {
+				seen[entryID] = true
+				relatedEntries = append(relatedEntries, entryID)
+			}
+		}
+	}
+
+	previous := ""
+	if prev, err := findPreviousAnalysis(app, userID, spec.analysisType, periodStart); err == nil && prev != nil {
+		previous = fmt.Sprintf("Previous %s growth score: %.0f\n", spec.analysisType, prev.GetFloat("growth_score"))
+	}
+
+	prompt, rendered, err := renderPrompt(app, spec.promptType, userID, rollupPromptData{
+		PeriodStart: periodStart.Format("2006-01-02"),
+		PeriodEnd:   periodEnd.Format("2006-01-02"),
+		Summaries:   summaries.String(),
+		Previous:    previous,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 3. Send to the AI provider
+	ctx, cancel := context.WithTimeout(ctx, aiRequestTimeout)
+	defer cancel()
+
+	resp, err := aiProvider.Generate(ctx, AIRequest{
+		Prompt:          prompt,
+		Temperature:     0.5,
+		MaxOutputTokens: rollupAnalysisMaxTokens,
+	})
+	if err != nil {
+		return err
+	}
+	recordJobUsage(job, resp)
+
+	result, err := parseRollupAnalysis(resp.Text)
+	if err != nil {
+		return err
+	}
+
+	// 4. Store (or replace) the summary for this period
+	if err := saveRollupAnalysis(app, userID, spec.analysisType, periodStart, periodEnd, relatedEntries, rendered, result); err != nil {
+		return err
+	}
+
+	log.Printf("✅ %s analysis stored for user %s (%d %s analyses, score: %.0f)", spec.analysisType, userID, len(sources), spec.sourceType, result.GrowthScore)
+	return nil
+}
+
+// findAnalysesInPeriod returns a user's analyses of the given type overlapping a period
+func findAnalysesInPeriod(app core.App, userID, analysisType string, start, end time.Time) ([]*core.Record, error) {
+	return app.FindRecordsByFilter(
+		"growth_analysis",
+		"user = {:userId} && analysis_type = {:type} && period_start <= {:end} && period_end >= {:start} && stale != true",
+		"period_start",
+		0,
+		0,
+		map[string]any{
+			"userId": userID,
+			"type":   analysisType,
+			"start":  start.UTC().Format(types.DefaultDateLayout),
+			"end":    end.UTC().Format(types.DefaultDateLayout),
+		},
+	)
+}
+
+// findPreviousAnalysis returns the most recent analysis of a type that ended before the given time
+func findPreviousAnalysis(app core.App, userID, analysisType string, before time.Time) (*core.Record, error) {
+	records, err := app.FindRecordsByFilter(
+		"growth_analysis",
+		"user = {:userId} && analysis_type = {:type} && period_end < {:before}",
+		"-period_end",
+		1,
+		0,
+		map[string]any{
+			"userId": userID,
+			"type":   analysisType,
+			"before": before.UTC().Format(types.DefaultDateLayout),
+		},
+	)
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return records[0], nil
+}
+
+// formatAnalysisSummary renders a growth_analysis record as a single prompt line
+func formatAnalysisSummary(record *core.Record) string {
+	var themes []string
+	_ = record.UnmarshalJSONField("key_themes", &themes)
+
+	start := record.GetDateTime("period_start").Time().Format("2006-01-02")
+	end := record.GetDateTime("period_end").Time().Format("2006-01-02")
+
+	period := start
+	if end != start {
+		period = start + " to " + end
+	}
+
+	return fmt.Sprintf("- %s: growth score %.0f, trend %s, themes: %s",
+		period,
+		record.GetFloat("growth_score"),
+		record.GetString("emotional_trend"),
+		strings.Join(themes, ", "),
+	)
+}
+
+// parseRollupAnalysis parses and normalizes the model output
+func parseRollupAnalysis(text string) (*rollupAnalysisResult, error) {
+	raw, err := extractJSONObject(text)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &rollupAnalysisResult{}
+	if err := json.Unmarshal([]byte(raw), result); err != nil {
+		return nil, fmt.Errorf("invalid rollup analysis JSON: %w", err)
+	}
+
+	result.GrowthScore = clampScore(result.GrowthScore)
+	result.EmotionalTrend = normalizeEmotionalTrend(result.EmotionalTrend, "")
+	if result.KeyThemes == nil {
+		result.KeyThemes = []string{}
+	}
+	if result.ActionItems == nil {
+		result.ActionItems = []string{}
+	}
+
+	return result, nil
+}
+
+// saveRollupAnalysis creates or updates the growth_analysis record for a period
+func saveRollupAnalysis(app core.App, userID, analysisType string, start, end time.Time, relatedEntries []string, rendered renderedPrompt, result *rollupAnalysisResult) error {
+	analysis, err := app.FindFirstRecordByFilter(
+		"growth_analysis",
+		"user = {:userId} && analysis_type = {:type} && period_start = {:start}",
+		map[string]any{
+			"userId": userID,
+			"type":   analysisType,
+			"start":  start.UTC().Format(types.DefaultDateLayout),
+		},
+	)
+	if err != nil {
+		collection, err := app.FindCollectionByNameOrId("growth_analysis")
+		if err != nil {
+			return err
+		}
+		analysis = core.NewRecord(collection)
+		analysis.Set("user", userID)
+		analysis.Set("analysis_type", analysisType)
+	}
+
+	analysis.Set("period_start", start.UTC())
+	analysis.Set("period_end", end.UTC())
+	analysis.Set("growth_score", result.GrowthScore)
+	analysis.Set("emotional_trend", result.EmotionalTrend)
+	analysis.Set("key_themes", result.KeyThemes)
+	analysis.Set("action_items", result.ActionItems)
+	analysis.Set("motivation_quote", result.MotivationQuote)
+	analysis.Set("related_entries", relatedEntries)
+	analysis.Set("stale", false)
+	rendered.set(analysis)
+
+	return app.Save(analysis)
+}
@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func TestHasUnfinishedWeeklyJobs(t *testing.T) {
+	app, err := tests.NewTestApp(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Cleanup()
+
+	queue, err := app.FindCollectionByNameOrId("ai_processing_queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	march := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	newJob := func(t *testing.T, userID, jobType, status string, start, end time.Time) *core.Record {
+		job := core.NewRecord(queue)
+		job.Set("user", userID)
+		job.Set("job_type", jobType)
+		job.Set("status", status)
+		job.Set("priority", 3)
+		job.Set("scheduled_at", time.Now().UTC())
+		job.Set("period_start", start)
+		job.Set("period_end", end.Add(-time.Second))
+		if err := app.Save(job); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+
+	scenarios := []struct {
+		name     string
+		status   string
+		start    time.Time
+		expected bool
+	}{
+		{"pending week in the month", "pending", march.AddDate(0, 0, 8), true},
+		{"processing week in the month", "processing", march.AddDate(0, 0, 15), true},
+		{"week starting in the previous month", "pending", march.AddDate(0, 0, -6), true},
+		{"week ending in the next month", "pending", march.AddDate(0, 0, 29), true},
+		{"completed week", "completed", march.AddDate(0, 0, 8), false},
+		{"failed week", "failed", march.AddDate(0, 0, 8), false},
+		{"week of the previous month", "pending", march.AddDate(0, 0, -7), false},
+		{"week of the next month", "pending", march.AddDate(0, 1, 0), false},
+	}
+
+	for i, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			user, _ := createTestEntry(t, app, fmt.Sprintf("weekly%d@example.com", i))
+			monthly := newJob(t, user.Id, "monthly_analysis", "processing", march, march.AddDate(0, 1, 0))
+			newJob(t, user.Id, "weekly_analysis", s.status, s.start, s.start.AddDate(0, 0, 7))
+
+			// Another user's weeks don't hold the job back
+			other, _ := createTestEntry(t, app, fmt.Sprintf("other%d@example.com", i))
+			newJob(t, other.Id, "weekly_analysis", "pending", march.AddDate(0, 0, 1), march.AddDate(0, 0, 8))
+
+			result, err := hasUnfinishedWeeklyJobs(app, monthly)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != s.expected {
+				t.Errorf("Expected %v, got %v", s.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package migrations
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/cron"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Number of users loaded per page while scheduling
+const schedulerUserPageSize = 100
+
+// Period kinds summarized by recurring jobs
+const (
+	periodDay   = "day"
+	periodWeek  = "week"
+	periodMonth = "month"
+)
+
+// recurringJob describes a job type that is enqueued on a schedule for every
+// eligible user. Schedules are cron expressions evaluated in the user's own
+// time zone; each run covers the last complete period (local calendar dates).
+type recurringJob struct {
+	jobType         string
+	envKey          string
+	defaultCron     string
+	period          string
+	priority        int
+	estimatedTokens int
+	frequencies     []string // preferred_analysis_frequency values that get this job (nil = all)
+	schedule        *cron.Schedule
+}
+
+// Default recurring jobs; schedules can be overridden through the env keys
+// (set to "off" to disable a job type). Job types whose processor is still a
+// stub are off by default.
+var recurringJobs = []*recurringJob{
+	{
+		jobType:         "daily_summary",
+		envKey:          "AI_SCHEDULE_DAILY_SUMMARY",
+		defaultCron:     "off", // e.g. "5 0 * * *" once implemented
+		period:          periodDay,
+		priority:        6,
+		estimatedTokens: 1000,
+		frequencies:     []string{"daily"},
+	},
+	{
+		jobType:         "weekly_analysis",
+		envKey:          "AI_SCHEDULE_WEEKLY_ANALYSIS",
+		defaultCron:     "15 0 * * 1",
+		period:          periodWeek,
+		priority:        8,
+		estimatedTokens: estimateRollupTokens(weeklyRollup, 7),
+	},
+	{
+		jobType:         "monthly_analysis",
+		envKey:          "AI_SCHEDULE_MONTHLY_ANALYSIS",
+		defaultCron:     "30 0 1 * *",
+		period:          periodMonth,
+		priority:        7,
+		estimatedTokens: estimateRollupTokens(monthlyRollup, 5),
+		frequencies:     []string{"daily", "monthly"},
+	},
+	{
+		jobType:         "growth_calculation",
+		envKey:          "AI_SCHEDULE_GROWTH_CALCULATION",
+		defaultCron:     "off", // e.g. "45 0 * * 1" once implemented
+		period:          periodWeek,
+		priority:        4,
+		estimatedTokens: 500,
+	},
+}
+
+// startRecurringJobScheduler registers the per-minute scheduler on the app cron
+// and catches up on any period missed while the app was down
+func startRecurringJobScheduler(app core.App) {
+	active := 0
+	for _, job := range recurringJobs {
+		expr := strings.TrimSpace(os.Getenv(job.envKey))
+		if expr == "" {
+			expr = job.defaultCron
+		}
+
+		if expr == "off" {
+			job.schedule = nil
+			log.Printf("ℹ️  Recurring %s jobs disabled", job.jobType)
+			continue
+		}
+
+		schedule, err := cron.NewSchedule(expr)
+		if err != nil {
+			log.Printf("Warning: Invalid %s schedule %q, using %q: %v", job.envKey, expr, job.defaultCron, err)
+			if job.defaultCron == "off" {
+				job.schedule = nil
+				continue
+			}
+			schedule, _ = cron.NewSchedule(job.defaultCron)
+			expr = job.defaultCron
+		}
+		job.schedule = schedule
+		active++
+
+		log.Printf("🗓️  Recurring %s jobs scheduled at %q (user local time)", job.jobType, expr)
+	}
+
+	if active == 0 {
+		return
+	}
+
+	app.Cron().MustAdd("aiRecurringJobs", "* * * * *", func() {
+		runRecurringJobs(app, time.Now(), false)
+	})
+
+	// Enqueue the latest period of every schedule in case its run was missed
+	go runRecurringJobs(app, time.Now(), true)
+}
+
+// runRecurringJobs enqueues every recurring job that is due for each user at
+// the given instant. With catchUp set, the most recent due time of each
+// schedule is used instead of requiring it to be due right now.
+func runRecurringJobs(app core.App, now time.Time, catchUp bool) {
+	now = now.Truncate(time.Minute)
+
+	timezones, err := distinctUserTimezones(app)
+	if err != nil {
+		log.Printf("Error loading user time zones: %v", err)
+		return
+	}
+
+	queued := 0
+	for _, tz := range timezones {
+		loc := loadLocation(tz)
+		localNow := now.In(loc)
+
+		// Collect the jobs due in this time zone and the period each one covers
+		type duePeriod struct {
+			job        *recurringJob
+			start, end time.Time
+		}
+		var due []duePeriod
+		for _, job := range recurringJobs {
+			if job.schedule == nil {
+				continue
+			}
+
+			dueAt := localNow
+			if catchUp {
+				var ok bool
+				if dueAt, ok = lastDueTime(job.schedule, localNow, job.period); !ok {
+					continue
+				}
+			} else if !job.schedule.IsDue(cron.NewMoment(localNow)) {
+				continue
+			}
+
+			start, end := lastCompletePeriod(dueAt, job.period)
+			due = append(due, duePeriod{job: job, start: start, end: end})
+		}
+
+		if len(due) == 0 {
+			continue
+		}
+
+		for offset := 0; ; offset += schedulerUserPageSize {
+			users, err := app.FindRecordsByFilter(
+				"users",
+				"timezone = {:tz}",
+				"created",
+				schedulerUserPageSize,
+				offset,
+				map[string]any{"tz": tz},
+			)
+			if err != nil {
+				log.Printf("Error loading users for recurring jobs: %v", err)
+				break
+			}
+
+			for _, user := range users {
+				for _, d := range due {
+					if !d.job.appliesTo(user.GetString("preferred_analysis_frequency")) {
+						continue
+					}
+					if !hasEntriesInPeriod(app, user.Id, loc, d.start, d.end) {
+						continue
+					}
+
+					ok, err := enqueueRecurringJob(app, user.Id, d.job, d.start, d.end, now)
+					if err != nil {
+						log.Printf("Warning: Failed to queue %s for user %s: %v", d.job.jobType, user.Id, err)
+					} else if ok {
+						queued++
+					}
+				}
+			}
+
+			if len(users) < schedulerUserPageSize {
+				break
+			}
+		}
+	}
+
+	if queued > 0 {
+		log.Printf("🗓️  Queued %d recurring jobs", queued)
+	}
+}
+
+// appliesTo reports whether users with the given analysis frequency get this job
+func (j *recurringJob) appliesTo(frequency string) bool {
+	if j.frequencies == nil {
+		return true
+	}
+	for _, f := range j.frequencies {
+		if f == frequency {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueueRecurringJob creates the job for a user and period unless one already
+// exists. Periods are local calendar dates stored as UTC midnights (see
+// LocalDate); end is exclusive and stored as the last second of the period.
+func enqueueRecurringJob(app core.App, userID string, recurring *recurringJob, start, end time.Time, now time.Time) (bool, error) {
+	existing, err := app.FindRecordsByFilter(
+		"ai_processing_queue",
+		"user = {:userId} && job_type = {:jobType} && period_start = {:start}",
+		"",
+		1,
+		0,
+		map[string]any{
+			"userId":  userID,
+			"jobType": recurring.jobType,
+			"start":   start.Format(types.DefaultDateLayout),
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	if len(existing) > 0 {
+		return false, nil
+	}
+
+	queueCollection, err := app.FindCollectionByNameOrId("ai_processing_queue")
+	if err != nil {
+		return false, err
+	}
+
+	scheduledAt := now.UTC()
+	if recurring.jobType == "monthly_analysis" {
+		// Monthly summaries are built from weekly ones, so wait until the week
+		// containing the last day of the month has been summarized in every
+		// time zone
+		if readyAt := startOfWeek(end.AddDate(0, 0, -1)).AddDate(0, 0, 7).Add(14 * time.Hour); readyAt.After(scheduledAt) {
+			scheduledAt = readyAt
+		}
+	}
+
+	job := core.NewRecord(queueCollection)
+	job.Set("user", userID)
+	job.Set("job_type", recurring.jobType)
+	job.Set("status", "pending")
+	job.Set("priority", recurring.priority)
+	job.Set("attempts", 0)
+	job.Set("scheduled_at", scheduledAt)
+	job.Set("period_start", start)
+	job.Set("period_end", end.Add(-time.Second))
+	job.Set("estimated_tokens", recurring.estimatedTokens)
+
+	if err := app.Save(job); err != nil {
+		// Another processor enqueued the same period first
+		if isUniqueConstraintError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// lastCompletePeriod returns the last period that ended at or before t, as
+// local calendar dates converted to UTC midnights
+func lastCompletePeriod(t time.Time, period string) (time.Time, time.Time) {
+	today := calendarDate(t)
+
+	switch period {
+	case periodWeek:
+		end := startOfWeek(today)
+		return end.AddDate(0, 0, -7), end
+	case periodMonth:
+		end := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return end.AddDate(0, -1, 0), end
+	default:
+		return today.AddDate(0, 0, -1), today
+	}
+}
+
+// lastDueTime finds the most recent minute at or before t (in t's location)
+// at which the schedule was due, looking back at most one period
+func lastDueTime(schedule *cron.Schedule, t time.Time, period string) (time.Time, bool) {
+	lookback := 24 * time.Hour
+	switch period {
+	case periodWeek:
+		lookback = 7 * 24 * time.Hour
+	case periodMonth:
+		lookback = 31 * 24 * time.Hour
+	}
+
+	for candidate := t; t.Sub(candidate) <= lookback; candidate = candidate.Add(-time.Minute) {
+		if schedule.IsDue(cron.NewMoment(candidate)) {
+			return candidate, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// distinctUserTimezones returns every time zone used by at least one user
+func distinctUserTimezones(app core.App) ([]string, error) {
+	var timezones []string
+	err := app.DB().
+		NewQuery("SELECT DISTINCT COALESCE(timezone, '') FROM users").
+		Column(&timezones)
+	return timezones, err
+}
+
+// loadLocation resolves an IANA time zone name, falling back to
+// AI_DEFAULT_TIMEZONE and finally UTC
+func loadLocation(name string) *time.Location {
+	if name == "" {
+		name = os.Getenv("AI_DEFAULT_TIMEZONE")
+	}
+	if name == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Warning: Unknown time zone %q, using UTC", name)
+		return time.UTC
+	}
+	return loc
+}
+
+// UserLocation returns the time zone configured on a user record
+func UserLocation(user *core.Record) *time.Location {
+	return loadLocation(user.GetString("timezone"))
+}
+
+// calendarDate returns the calendar date of t (in t's location) as a UTC midnight
+func calendarDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// LocalDate returns the calendar date of an instant in the given time zone,
+// as a UTC midnight. entry_date is an instant; an entry belongs to the local
+// date it falls on for its user, for streaks, periods and the heatmap alike.
+func LocalDate(t time.Time, loc *time.Location) time.Time {
+	return calendarDate(t.In(loc))
+}
+
+// EntryLocalDate returns the local date of an entry in its user's time zone
+func EntryLocalDate(app core.App, entry *core.Record) (time.Time, bool) {
+	entryDate := entry.GetDateTime("entry_date").Time()
+	if entryDate.IsZero() {
+		return time.Time{}, false
+	}
+
+	loc := loadLocation("")
+	if user, err := app.FindRecordById("users", entry.GetString("user")); err == nil {
+		loc = UserLocation(user)
+	}
+
+	return LocalDate(entryDate, loc), true
+}
+
+// localDayStart returns the instant a local calendar date (a UTC midnight)
+// begins in the given time zone
+func localDayStart(date time.Time, loc *time.Location) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+}
+
+// hasEntriesInPeriod reports whether a user wrote at least one entry on the
+// local dates [start, end)
+func hasEntriesInPeriod(app core.App, userID string, loc *time.Location, start, end time.Time) bool {
+	entries, err := app.FindRecordsByFilter(
+		"journal_entries",
+		"user = {:userId} && entry_date >= {:start} && entry_date < {:end}",
+		"",
+		1,
+		0,
+		map[string]any{
+			"userId": userID,
+			"start":  localDayStart(start, loc).UTC().Format(types.DefaultDateLayout),
+			"end":    localDayStart(end, loc).UTC().Format(types.DefaultDateLayout),
+		},
+	)
+	return err == nil && len(entries) > 0
+}
+
+// startOfWeek returns Monday 00:00 of the week containing t
+func startOfWeek(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	weekday := (int(day.Weekday()) + 6) % 7 // Monday = 0
+	return day.AddDate(0, 0, -weekday)
+}
+
+// isUniqueConstraintError reports whether a save failed on a unique index
+func isUniqueConstraintError(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "unique")
+}
@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalDate(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		name      string
+		entryDate string
+		loc       *time.Location
+		expected  string
+	}{
+		{"utc", "2026-03-01T23:30:00Z", time.UTC, "2026-03-01"},
+		{"late evening in Tokyo", "2026-03-01T14:00:00Z", tokyo, "2026-03-01"},
+		{"next morning in Tokyo", "2026-03-01T23:00:00Z", tokyo, "2026-03-02"},
+		{"evening in New York is the previous day", "2026-03-02T03:00:00Z", newYork, "2026-03-01"},
+		{"day DST starts in New York", "2026-03-08T12:00:00Z", newYork, "2026-03-08"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			entryDate, err := time.Parse(time.RFC3339, s.entryDate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := LocalDate(entryDate, s.loc)
+			if result.Format("2006-01-02") != s.expected || result.Location() != time.UTC || result.Hour() != 0 {
+				t.Errorf("Expected %s at UTC midnight, got %v", s.expected, result)
+			}
+		})
+	}
+}
+
+func TestEntryLocalDateMatchesPeriods(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Monday 08:00 in Tokyo is still Sunday in UTC
+	entryDate, _ := time.Parse(time.RFC3339, "2026-03-01T23:00:00Z")
+	local := LocalDate(entryDate, tokyo)
+
+	// The weekly run on the next Monday covers the week the entry was written in
+	start, end := lastCompletePeriod(time.Date(2026, 3, 9, 0, 15, 0, 0, tokyo), periodWeek)
+	if local.Before(start) || !local.Before(end) {
+		t.Fatalf("Expected %s within [%s, %s)", local.Format("2006-01-02"), start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+
+	// And the entry's instant lies within the local bounds of that week
+	if entryDate.Before(localDayStart(start, tokyo)) || !entryDate.Before(localDayStart(end, tokyo)) {
+		t.Errorf("Expected %v within the local bounds of the week", entryDate)
+	}
+}
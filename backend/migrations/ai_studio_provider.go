@@ -0,0 +1,166 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Default model used when AI_STUDIO_MODEL is not set
+const defaultAIStudioModel = "gemma-3-27b-it"
+
+// Google AI Studio (Gemini API) base URL
+const aiStudioBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+
+// AIStudioProvider calls the Google AI Studio generateContent API
+type AIStudioProvider struct {
+	apiKey     string
+	model      string
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewAIStudioProvider creates a new Google AI Studio provider
+func NewAIStudioProvider(apiKey, model string) *AIStudioProvider {
+	if model == "" {
+		model = defaultAIStudioModel
+	}
+
+	return &AIStudioProvider{
+		apiKey:     apiKey,
+		model:      model,
+		baseURL:    aiStudioBaseURL,
+		httpClient: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Name returns the provider name
+func (p *AIStudioProvider) Name() string {
+	return "aistudio/" + p.model
+}
+
+type aiStudioPart struct {
+	Text string `json:"text"`
+}
+
+type aiStudioContent struct {
+	Role  string         `json:"role,omitempty"`
+	Parts []aiStudioPart `json:"parts"`
+}
+
+type aiStudioGenerationConfig struct {
+	Temperature     float64 `json:"temperature,omitempty"`
+	MaxOutputTokens int     `json:"maxOutputTokens,omitempty"`
+}
+
+type aiStudioRequest struct {
+	Contents         []aiStudioContent        `json:"contents"`
+	GenerationConfig aiStudioGenerationConfig `json:"generationConfig"`
+}
+
+type aiStudioResponse struct {
+	Candidates []struct {
+		Content      aiStudioContent `json:"content"`
+		FinishReason string          `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// Generate sends the prompt to the configured model
+func (p *AIStudioProvider) Generate(ctx context.Context, req AIRequest) (*AIResponse, error) {
+	body, err := json.Marshal(aiStudioRequest{
+		Contents: []aiStudioContent{
+			{Role: "user", Parts: []aiStudioPart{{Text: req.Prompt}}},
+		},
+		GenerationConfig: aiStudioGenerationConfig{
+			Temperature:     req.Temperature,
+			MaxOutputTokens: req.MaxOutputTokens,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/models/%s:generateContent", p.baseURL, url.PathEscape(p.model))
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-goog-api-key", p.apiKey)
+
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, transportError(p.Name(), err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &AIProviderError{Provider: p.Name(), StatusCode: resp.StatusCode, Message: err.Error()}
+	}
+
+	var parsed aiStudioResponse
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return nil, &AIProviderError{Provider: p.Name(), StatusCode: resp.StatusCode, Message: "invalid response body"}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message := resp.Status
+		if parsed.Error != nil && parsed.Error.Message != "" {
+			message = parsed.Error.Message
+		}
+		return nil, &AIProviderError{Provider: p.Name(), StatusCode: resp.StatusCode, Message: message}
+	}
+
+	if len(parsed.Candidates) == 0 {
+		return nil, &AIProviderError{Provider: p.Name(), StatusCode: resp.StatusCode, Message: "no candidates returned"}
+	}
+
+	var text strings.Builder
+	for _, part := range parsed.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+
+	return &AIResponse{
+		Text:             text.String(),
+		PromptTokens:     parsed.UsageMetadata.PromptTokenCount,
+		CompletionTokens: parsed.UsageMetadata.CandidatesTokenCount,
+	}, nil
+}
+
+// transportError describes a failed request without the request URL, since
+// the message ends up in job errors shown to users
+func transportError(provider string, err error) *AIProviderError {
+	message := err.Error()
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		switch {
+		case urlErr.Timeout():
+			message = "request timed out"
+		case errors.Is(urlErr.Err, context.Canceled):
+			message = "request cancelled"
+		default:
+			message = "request failed: " + urlErr.Err.Error()
+		}
+	}
+
+	return &AIProviderError{Provider: provider, Message: message, Err: err}
+}
@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"log"
+	"math"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Output token limits requested from the model
+const (
+	entryAnalysisMaxTokens  = 512
+	rollupAnalysisMaxTokens = 768
+)
+
+const (
+	// Rough tokens per word of journal prose
+	tokensPerWord = 1.35
+
+	// Word count assumed when an entry doesn't report one
+	defaultEntryWordCount = 300
+
+	// Rough size of one formatAnalysisSummary line
+	analysisSummaryTokens = 40
+
+	// Estimate for jobs created without one
+	defaultJobTokenEstimate = 1000
+)
+
+// EstimateEntryAnalysisTokens estimates the prompt and completion tokens of an
+// entry analysis from the entry's word count
+func EstimateEntryAnalysisTokens(wordCount int) int {
+	if wordCount <= 0 {
+		wordCount = defaultEntryWordCount
+	}
+
+	prompt := embeddedPromptTokens("entry_analysis") + int(math.Ceil(float64(wordCount)*tokensPerWord))
+	return prompt + entryAnalysisMaxTokens
+}
+
+// estimateRollupTokens estimates the tokens of a rollup summarizing the given
+// number of lower-level analyses
+func estimateRollupTokens(spec rollupSpec, sources int) int {
+	return embeddedPromptTokens(spec.promptType) + sources*analysisSummaryTokens + rollupAnalysisMaxTokens
+}
+
+// jobTokenEstimate returns the tokens debited from the bucket for a job
+func jobTokenEstimate(job *core.Record) float64 {
+	if estimated := job.GetInt("estimated_tokens"); estimated > 0 {
+		return float64(estimated)
+	}
+	return defaultJobTokenEstimate
+}
+
+// recordJobUsage adds the usage reported by the provider to the job's
+// prompt_tokens and completion_tokens (saved with the job)
+func recordJobUsage(job *core.Record, resp *AIResponse) {
+	job.Set("prompt_tokens", job.GetInt("prompt_tokens")+resp.PromptTokens)
+	job.Set("completion_tokens", job.GetInt("completion_tokens")+resp.CompletionTokens)
+}
+
+// settleJobTokens corrects the token bucket once an attempt is done (the
+// estimate was debited up front, the real usage is what counts) and adds the
+// usage to the user's quota counters
+func settleJobTokens(app core.App, job *core.Record) {
+	estimated := jobTokenEstimate(job)
+	used := float64(job.GetInt("prompt_tokens") + job.GetInt("completion_tokens"))
+
+	aiTokenBucket.Adjust(estimated - used)
+
+	if used > 0 {
+		log.Printf("📏 Job %s used %.0f tokens (estimated %.0f)", job.Id, used, estimated)
+
+		if err := recordUserTokenUsage(app, job.GetString("user"), int(used)); err != nil {
+			log.Printf("Warning: Failed to record token usage for job %s: %v", job.Id, err)
+		}
+	}
+}
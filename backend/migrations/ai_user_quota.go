@@ -0,0 +1,207 @@
+package migrations
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Job types that never call the AI provider and so don't count against quotas
+var quotaExemptJobTypes = map[string]bool{
+	"streak_update": true,
+}
+
+// userQuota is a user's AI token budget and usage in their local day and month
+type userQuota struct {
+	daily     int // 0 = unlimited
+	monthly   int // 0 = unlimited
+	usedToday int
+	usedMonth int
+	day       string // local date the usage applies to (YYYY-MM-DD)
+	month     string // local month the usage applies to (YYYY-MM)
+	location  *time.Location
+}
+
+// loadUserQuota resolves a user's quota at the given time. Counters from an
+// earlier day or month count as zero.
+func loadUserQuota(user *core.Record, now time.Time) userQuota {
+	loc := UserLocation(user)
+	local := now.In(loc)
+
+	q := userQuota{
+		daily:    user.GetInt("ai_daily_token_quota"),
+		monthly:  user.GetInt("ai_monthly_token_quota"),
+		day:      local.Format("2006-01-02"),
+		month:    local.Format("2006-01"),
+		location: loc,
+	}
+	if q.daily <= 0 {
+		q.daily = int(getEnvFloat("AI_USER_DAILY_TOKENS", 50000))
+	}
+	if q.monthly <= 0 {
+		q.monthly = int(getEnvFloat("AI_USER_MONTHLY_TOKENS", 500000))
+	}
+
+	if user.GetString("ai_usage_day") == q.day {
+		q.usedToday = user.GetInt("ai_tokens_today")
+	}
+	if user.GetString("ai_usage_month") == q.month {
+		q.usedMonth = user.GetInt("ai_tokens_month")
+	}
+
+	return q
+}
+
+// allows reports whether the user may spend the given number of tokens. The
+// first job of a day or month is always allowed, so a job larger than the
+// whole budget can't be deferred forever.
+func (q userQuota) allows(tokens int) bool {
+	if q.daily > 0 && q.usedToday > 0 && q.usedToday+tokens > q.daily {
+		return false
+	}
+	if q.monthly > 0 && q.usedMonth > 0 && q.usedMonth+tokens > q.monthly {
+		return false
+	}
+	return true
+}
+
+// share is the fraction of the daily budget already used. Users with a
+// bigger budget get a proportionally bigger share of the queue.
+func (q userQuota) share() float64 {
+	if q.daily <= 0 {
+		return 0
+	}
+	return float64(q.usedToday) / float64(q.daily)
+}
+
+// resetsAt returns when the exhausted budget is available again: the next
+// local midnight, or the next month when the monthly budget is used up
+func (q userQuota) resetsAt(now time.Time, tokens int) time.Time {
+	local := now.In(q.location)
+	if q.monthly > 0 && q.usedMonth+tokens > q.monthly {
+		return time.Date(local.Year(), local.Month()+1, 1, 0, 0, 0, 0, q.location)
+	}
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, q.location)
+}
+
+// recordUserTokenUsage adds tokens to the user's daily and monthly counters,
+// starting over when the local day or month changed
+func recordUserTokenUsage(app core.App, userID string, tokens int) error {
+	if tokens <= 0 {
+		return nil
+	}
+
+	user, err := app.FindRecordById("users", userID)
+	if err != nil {
+		return err
+	}
+
+	local := time.Now().In(UserLocation(user))
+
+	// Single UPDATE so concurrent workers don't lose increments
+	_, err = app.DB().NewQuery(`
+		UPDATE users
+		SET ai_tokens_today = (CASE WHEN ai_usage_day = {:day} THEN ai_tokens_today ELSE 0 END) + {:tokens},
+			ai_usage_day = {:day},
+			ai_tokens_month = (CASE WHEN ai_usage_month = {:month} THEN ai_tokens_month ELSE 0 END) + {:tokens},
+			ai_usage_month = {:month}
+		WHERE id = {:id}
+	`).Bind(dbx.Params{
+		"id":     userID,
+		"tokens": tokens,
+		"day":    local.Format("2006-01-02"),
+		"month":  local.Format("2006-01"),
+	}).Execute()
+
+	return err
+}
+
+// orderByFairShare orders candidate jobs by priority after aging, then by how
+// much of their budget the users already used today (weighted fair queuing),
+// then by scheduled time. Jobs of users over their quota are returned separately.
+func orderByFairShare(app core.App, jobs []*core.Record, now time.Time) (runnable []*core.Record, overQuota []*core.Record, quotas map[string]userQuota) {
+	quotas = map[string]userQuota{}
+
+	userIDs := []string{}
+	for _, job := range jobs {
+		if userID := job.GetString("user"); userID != "" {
+			if _, ok := quotas[userID]; !ok {
+				quotas[userID] = userQuota{}
+				userIDs = append(userIDs, userID)
+			}
+		}
+	}
+
+	users, err := app.FindRecordsByIds("users", userIDs)
+	if err != nil {
+		log.Printf("Warning: Failed to load user quotas: %v", err)
+	}
+	for _, user := range users {
+		quotas[user.Id] = loadUserQuota(user, now)
+	}
+
+	for _, job := range jobs {
+		quota := quotas[job.GetString("user")]
+		if !quotaExemptJobTypes[job.GetString("job_type")] && !quota.allows(int(jobTokenEstimate(job))) {
+			overQuota = append(overQuota, job)
+			continue
+		}
+		runnable = append(runnable, job)
+	}
+
+	sort.SliceStable(runnable, func(i, j int) bool {
+		a, b := runnable[i], runnable[j]
+		if pa, pb := effectivePriority(a, now), effectivePriority(b, now); pa != pb {
+			return pa > pb
+		}
+		if sa, sb := quotas[a.GetString("user")].share(), quotas[b.GetString("user")].share(); sa != sb {
+			return sa < sb
+		}
+		return a.GetDateTime("scheduled_at").Time().Before(b.GetDateTime("scheduled_at").Time())
+	})
+
+	return runnable, overQuota, quotas
+}
+
+// deferJobForQuota moves a claimable job (pending, or with an expired lease)
+// back to pending until the user's budget resets. A job with an expired lease
+// that a newer request superseded is cancelled instead.
+func deferJobForQuota(app core.App, job *core.Record, quota userQuota, now time.Time) {
+	if job.GetString("status") == "processing" {
+		superseded, err := hasWaitingDuplicate(app, job)
+		if err != nil {
+			log.Printf("Warning: Failed to defer job %s: %v", job.Id, err)
+			return
+		}
+		if superseded {
+			if err := cancelJobs(app, []string{job.Id}, supersededJobReason); err != nil {
+				log.Printf("Warning: Failed to cancel job %s: %v", job.Id, err)
+			}
+			return
+		}
+	}
+
+	resetsAt := quota.resetsAt(now, int(jobTokenEstimate(job))).UTC()
+
+	_, err := app.DB().NewQuery(`
+		UPDATE ai_processing_queue
+		SET status = 'pending', scheduled_at = {:at}, locked_by = '', lease_expires_at = ''
+		WHERE id = {:id}
+			AND (status = 'pending' OR (status = 'processing' AND lease_expires_at < {:now}))
+	`).Bind(dbx.Params{
+		"id":  job.Id,
+		"at":  resetsAt.Format(types.DefaultDateLayout),
+		"now": now.UTC().Format(types.DefaultDateLayout),
+	}).Execute()
+	if err != nil {
+		log.Printf("Warning: Failed to defer job %s: %v", job.Id, err)
+		return
+	}
+
+	log.Printf("🪫 User %s is over their AI quota, job %s deferred to %s", job.GetString("user"), job.Id, resetsAt.Format(time.RFC3339))
+	notifyJobStatusByID(app, job.Id)
+}
@@ -0,0 +1,242 @@
+package migrations
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// workerPool runs claimed jobs on a fixed number of workers. The poller only
+// claims as many jobs as there are idle workers (back-pressure), and no more
+// than perUserLimit jobs of the same user run at once.
+type workerPool struct {
+	app          core.App
+	size         int
+	perUserLimit int
+	jobs         chan *core.Record
+	wake         chan struct{}
+
+	// ctx is passed to running jobs and cancelled when the drain deadline passes
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// stopPolling stops the poller; pollerDone is closed once it returned
+	stopPolling context.CancelFunc
+	pollerDone  chan struct{}
+
+	mu      sync.Mutex
+	running int
+	perUser map[string]int
+	wg      sync.WaitGroup
+}
+
+// Global worker pool, created by StartAIQueueProcessor
+var aiWorkerPool *workerPool
+
+// newWorkerPool creates a pool sized from AI_QUEUE_WORKERS and
+// AI_QUEUE_PER_USER_CONCURRENCY
+func newWorkerPool(app core.App) *workerPool {
+	size := int(getEnvFloat("AI_QUEUE_WORKERS", 4))
+	if size < 1 {
+		size = 1
+	}
+
+	perUserLimit := int(getEnvFloat("AI_QUEUE_PER_USER_CONCURRENCY", 1))
+	if perUserLimit < 1 {
+		perUserLimit = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &workerPool{
+		app:          app,
+		ctx:          ctx,
+		cancel:       cancel,
+		pollerDone:   make(chan struct{}),
+		size:         size,
+		perUserLimit: perUserLimit,
+		jobs:         make(chan *core.Record, size),
+		wake:         make(chan struct{}, 1),
+		perUser:      map[string]int{},
+	}
+}
+
+// start launches the workers and the poller feeding them. A finished job
+// wakes the poller early so a backlog doesn't wait for the next tick.
+func (p *workerPool) start(interval time.Duration) {
+	for i := 0; i < p.size; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	pollCtx, stopPolling := context.WithCancel(context.Background())
+	p.stopPolling = stopPolling
+
+	go func() {
+		defer close(p.pollerDone)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-pollCtx.Done():
+				return
+			case <-ticker.C:
+			case <-p.wake:
+			}
+			p.processPendingJobs()
+		}
+	}()
+}
+
+// shutdown stops claiming new jobs and waits up to timeout for the running
+// ones. Jobs still running after that are interrupted, and every job this
+// processor holds is put back to pending for the next run to pick up.
+func (p *workerPool) shutdown(timeout time.Duration) {
+	p.stopPolling()
+	<-p.pollerDone
+
+	// The poller was the only sender
+	close(p.jobs)
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("✅ AI queue drained")
+	case <-time.After(timeout):
+		log.Printf("⚠️  AI queue drain timed out after %v, interrupting running jobs", timeout)
+		p.cancel()
+
+		// Give interrupted jobs a moment to return before releasing their leases
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}
+	p.cancel()
+
+	released, err := releaseHeldJobs(p.app)
+	if err != nil {
+		log.Printf("Warning: Failed to release unfinished jobs: %v", err)
+	}
+	if released > 0 {
+		log.Printf("↩️  Put %d unfinished jobs back to pending", released)
+	}
+}
+
+// work runs jobs until the jobs channel is closed
+func (p *workerPool) work() {
+	defer p.wg.Done()
+
+	for job := range p.jobs {
+		if err := processJob(p.ctx, p.app, job); err != nil {
+			log.Printf("❌ Failed to process job %s: %v", job.Id, err)
+		}
+		p.release(job.GetString("user"))
+
+		// Let the poller hand out the free slot right away
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// idle returns the number of workers without a job
+func (p *workerPool) idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.size - p.running
+}
+
+// acquire reserves a worker slot for the job's user. It fails when the pool
+// is full or the user already has perUserLimit jobs running.
+func (p *workerPool) acquire(userID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running >= p.size || p.perUser[userID] >= p.perUserLimit {
+		return false
+	}
+
+	p.running++
+	p.perUser[userID]++
+	return true
+}
+
+// release frees a slot taken by acquire
+func (p *workerPool) release(userID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.running--
+	if p.perUser[userID]--; p.perUser[userID] <= 0 {
+		delete(p.perUser, userID)
+	}
+}
+
+// processPendingJobs claims due jobs for the idle workers
+func (p *workerPool) processPendingJobs() {
+	idle := p.idle()
+	if idle == 0 {
+		return // all workers busy, leave the jobs for another processor or the next poll
+	}
+
+	// Find due jobs (and jobs with an expired lease), the first few of each user.
+	// Fetch a few extra so users at their concurrency limit don't starve the others.
+	candidates, err := findClaimableJobs(p.app, idle*3, p.perUserLimit)
+	if err != nil {
+		log.Printf("Error finding pending jobs: %v", err)
+		return
+	}
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	// Share the budget fairly: users who used less of their quota today go first,
+	// users over their quota wait until it resets
+	now := time.Now()
+	jobs, overQuota, quotas := orderByFairShare(p.app, candidates, now)
+	for _, job := range overQuota {
+		deferJobForQuota(p.app, job, quotas[job.GetString("user")], now)
+	}
+
+	if len(jobs) == 0 {
+		return
+	}
+
+	log.Printf("📋 Found %d pending AI jobs (%d idle workers)", len(jobs), idle)
+
+	for _, candidate := range jobs {
+		userID := candidate.GetString("user")
+		if !p.acquire(userID) {
+			if p.idle() == 0 {
+				return
+			}
+			continue // user is at their concurrency limit
+		}
+
+		// Atomically claim the job so no other processor runs it
+		job, err := claimJob(p.app, candidate.Id)
+		if err != nil || job == nil {
+			if err != nil {
+				log.Printf("❌ Failed to claim job %s: %v", candidate.Id, err)
+			}
+			p.release(userID)
+			continue // nil job: claimed by another processor
+		}
+
+		// Never blocks: the channel holds one job per worker and a slot was reserved
+		p.jobs <- job
+	}
+}
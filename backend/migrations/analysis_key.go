@@ -0,0 +1,390 @@
+package migrations
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Maximum number of audit events kept on the user record
+const analysisKeyAuditLimit = 100
+
+// Size of the client journal key (AES-256)
+const analysisKeySize = 32
+
+// Server key pair used by clients to wrap their analysis key
+var (
+	analysisKeyOnce    sync.Once
+	analysisPrivateKey *rsa.PrivateKey
+	analysisKeyErr     error
+)
+
+// loadAnalysisPrivateKey loads the server's RSA key pair, generating and
+// persisting a new one on first use
+func loadAnalysisPrivateKey(app core.App) (*rsa.PrivateKey, error) {
+	analysisKeyOnce.Do(func() {
+		keyFile := os.Getenv("AI_ANALYSIS_KEY_FILE")
+		if keyFile == "" {
+			keyFile = filepath.Join(app.DataDir(), "analysis_key.pem")
+		}
+
+		if data, err := os.ReadFile(keyFile); err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil {
+				analysisKeyErr = fmt.Errorf("invalid PEM in %s", keyFile)
+				return
+			}
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				analysisKeyErr = err
+				return
+			}
+			privateKey, ok := parsed.(*rsa.PrivateKey)
+			if !ok {
+				analysisKeyErr = fmt.Errorf("%s does not contain an RSA private key", keyFile)
+				return
+			}
+			analysisPrivateKey = privateKey
+			return
+		}
+
+		privateKey, err := rsa.GenerateKey(rand.Reader, 3072)
+		if err != nil {
+			analysisKeyErr = err
+			return
+		}
+
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			analysisKeyErr = err
+			return
+		}
+
+		pemData := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(keyFile, pemData, 0600); err != nil {
+			analysisKeyErr = err
+			return
+		}
+
+		log.Printf("🔑 Generated new analysis key pair at %s", keyFile)
+		analysisPrivateKey = privateKey
+	})
+
+	return analysisPrivateKey, analysisKeyErr
+}
+
+// unwrapAnalysisKey decrypts a base64 RSA-OAEP (SHA-256) wrapped analysis key
+func unwrapAnalysisKey(app core.App, wrapped string) ([]byte, error) {
+	privateKey, err := loadAnalysisPrivateKey(app)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, errors.New("wrapped key is not valid base64")
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("failed to unwrap analysis key")
+	}
+
+	if len(key) != analysisKeySize {
+		clear(key)
+		return nil, fmt.Errorf("analysis key must be %d bytes", analysisKeySize)
+	}
+
+	return key, nil
+}
+
+// analysisKeyring holds a user's unwrapped analysis key for the duration of
+// a single processJob call. The key only ever lives in memory and must be
+// wiped with Wipe once the job finishes.
+type analysisKeyring struct {
+	app    core.App
+	job    *core.Record
+	key    []byte
+	loaded bool
+	err    error
+}
+
+// newAnalysisKeyring creates a keyring for the given job; the key is unwrapped lazily
+func newAnalysisKeyring(app core.App, job *core.Record) *analysisKeyring {
+	return &analysisKeyring{app: app, job: job}
+}
+
+// Key unwraps (once) and returns the job owner's analysis key
+func (k *analysisKeyring) Key() ([]byte, error) {
+	if k.loaded {
+		return k.key, k.err
+	}
+	k.loaded = true
+
+	user, err := k.app.FindRecordById("users", k.job.GetString("user"))
+	if err != nil {
+		k.err = err
+		return nil, err
+	}
+
+	wrapped := user.GetString("analysis_key_wrapped")
+	if !user.GetBool("server_analysis_enabled") || wrapped == "" {
+		k.err = errEntryContentEncrypted
+		return nil, k.err
+	}
+
+	expiresAt := user.GetDateTime("analysis_key_expires_at").Time()
+	if expiresAt.IsZero() || time.Now().UTC().After(expiresAt) {
+		// Drop the expired key so it can never be used again
+		user.Set("analysis_key_wrapped", "")
+		appendAnalysisKeyAudit(user, "expired", k.job)
+		if err := k.app.Save(user); err != nil {
+			log.Printf("Warning: Failed to clear expired analysis key: %v", err)
+		}
+		k.err = errEntryContentEncrypted
+		return nil, k.err
+	}
+
+	key, err := unwrapAnalysisKey(k.app, wrapped)
+	if err != nil {
+		k.err = err
+		return nil, err
+	}
+	k.key = key
+
+	// Audit every use of the key on the user record
+	user.Set("analysis_key_last_used_at", time.Now().UTC())
+	appendAnalysisKeyAudit(user, "used", k.job)
+	if err := k.app.Save(user); err != nil {
+		k.Wipe()
+		k.err = fmt.Errorf("failed to audit analysis key use: %w", err)
+		return nil, k.err
+	}
+
+	log.Printf("🔓 Unwrapped analysis key for user %s (job %s)", user.Id, k.job.Id)
+	return k.key, nil
+}
+
+// HasKey reports whether the key has been unwrapped successfully
+func (k *analysisKeyring) HasKey() bool {
+	return k.loaded && k.err == nil && k.key != nil
+}
+
+// Wipe zeroes the key material
+func (k *analysisKeyring) Wipe() {
+	if k.key != nil {
+		clear(k.key)
+		k.key = nil
+	}
+}
+
+// appendAnalysisKeyAudit adds an audit event to the user's analysis key audit trail
+func appendAnalysisKeyAudit(user *core.Record, action string, job *core.Record) {
+	var events []map[string]any
+	if raw := user.GetString("analysis_key_audit"); raw != "" && raw != "null" {
+		if err := json.Unmarshal([]byte(raw), &events); err != nil {
+			events = nil
+		}
+	}
+
+	event := map[string]any{
+		"at":     time.Now().UTC().Format(time.RFC3339),
+		"action": action,
+	}
+	if job != nil {
+		event["job_id"] = job.Id
+		event["job_type"] = job.GetString("job_type")
+	}
+
+	events = append(events, event)
+	if len(events) > analysisKeyAuditLimit {
+		events = events[len(events)-analysisKeyAuditLimit:]
+	}
+
+	user.Set("analysis_key_audit", events)
+}
+
+// decryptEntryContent decrypts content in the frontend/src/lib/encryption.ts
+// format (hex IV ":" base64 ciphertext) as AES-256-CBC with PKCS#7 padding,
+// since crypto-js ships no GCM mode.
+func decryptEntryContent(encrypted string, key []byte) (string, error) {
+	parts := strings.SplitN(encrypted, ":", 2)
+	if len(parts) != 2 {
+		return "", errors.New("invalid encrypted data format")
+	}
+
+	iv, err := hex.DecodeString(parts[0])
+	if err != nil || len(iv) != aes.BlockSize {
+		return "", errors.New("invalid IV in encrypted data")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return "", errors.New("invalid ciphertext in encrypted data")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		clear(plaintext)
+		return "", errors.New("failed to decrypt entry content")
+	}
+
+	content := string(plaintext[:len(plaintext)-padding])
+	clear(plaintext)
+	return content, nil
+}
+
+// encryptEntryContent encrypts content in the same format the frontend uses,
+// so the client can decrypt it with its journal key
+func encryptEntryContent(content string, key []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	plaintext := append([]byte(content), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, plaintext)
+	clear(plaintext)
+
+	return hex.EncodeToString(iv) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// RegisterAnalysisKeyRoutes registers the endpoints used to opt in and out of
+// server-side decryption for AI analysis
+func RegisterAnalysisKeyRoutes(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		group := se.Router.Group("/api/ai/analysis-key")
+		group.Bind(apis.RequireAuth("users"))
+
+		// Public key the client uses to wrap its analysis key
+		group.GET("", func(e *core.RequestEvent) error {
+			privateKey, err := loadAnalysisPrivateKey(app)
+			if err != nil {
+				return e.InternalServerError("Analysis key is not available.", err)
+			}
+
+			der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+			if err != nil {
+				return e.InternalServerError("Analysis key is not available.", err)
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"public_key":      string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+				"algorithm":       "RSA-OAEP-256",
+				"enabled":         e.Auth.GetBool("server_analysis_enabled"),
+				"expires_at":      e.Auth.GetString("analysis_key_expires_at"),
+				"max_ttl_seconds": int(analysisKeyMaxTTL().Seconds()),
+			})
+		})
+
+		// Opt in: store a short-lived wrapped analysis key
+		group.POST("", func(e *core.RequestEvent) error {
+			body := struct {
+				WrappedKey string `json:"wrapped_key"`
+				TTLSeconds int    `json:"ttl_seconds"`
+			}{}
+			if err := e.BindBody(&body); err != nil {
+				return e.BadRequestError("Invalid request body.", err)
+			}
+
+			key, err := unwrapAnalysisKey(app, body.WrappedKey)
+			if err != nil {
+				return e.BadRequestError("Invalid wrapped key.", err)
+			}
+
+			// The key must match the hash registered by the client (hashKey in encryption.ts)
+			keyHash := sha256.Sum256([]byte(hex.EncodeToString(key)))
+			clear(key)
+			if storedHash := e.Auth.GetString("encryption_key_hash"); storedHash != "" && storedHash != hex.EncodeToString(keyHash[:]) {
+				return e.BadRequestError("Wrapped key does not match the registered encryption key.", nil)
+			}
+
+			ttl := time.Duration(body.TTLSeconds) * time.Second
+			if ttl <= 0 || ttl > analysisKeyMaxTTL() {
+				ttl = analysisKeyMaxTTL()
+			}
+			expiresAt := time.Now().UTC().Add(ttl)
+
+			user, err := app.FindRecordById("users", e.Auth.Id)
+			if err != nil {
+				return e.NotFoundError("User not found.", err)
+			}
+
+			user.Set("server_analysis_enabled", true)
+			user.Set("analysis_key_wrapped", body.WrappedKey)
+			user.Set("analysis_key_expires_at", expiresAt)
+			appendAnalysisKeyAudit(user, "granted", nil)
+			if err := app.Save(user); err != nil {
+				return e.InternalServerError("Failed to store analysis key.", err)
+			}
+
+			log.Printf("🔑 User %s granted an analysis key until %s", user.Id, expiresAt.Format(time.RFC3339))
+			return e.JSON(http.StatusOK, map[string]any{
+				"enabled":    true,
+				"expires_at": expiresAt.Format(time.RFC3339),
+			})
+		})
+
+		// Opt out: drop the wrapped analysis key immediately
+		group.DELETE("", func(e *core.RequestEvent) error {
+			user, err := app.FindRecordById("users", e.Auth.Id)
+			if err != nil {
+				return e.NotFoundError("User not found.", err)
+			}
+
+			user.Set("server_analysis_enabled", false)
+			user.Set("analysis_key_wrapped", "")
+			user.Set("analysis_key_expires_at", "")
+			appendAnalysisKeyAudit(user, "revoked", nil)
+			if err := app.Save(user); err != nil {
+				return e.InternalServerError("Failed to revoke analysis key.", err)
+			}
+
+			log.Printf("🔒 User %s revoked their analysis key", user.Id)
+			return e.NoContent(http.StatusNoContent)
+		})
+
+		return se.Next()
+	})
+}
+
+// analysisKeyMaxTTL returns the longest lifetime allowed for a wrapped analysis key
+func analysisKeyMaxTTL() time.Duration {
+	hours := getEnvFloat("AI_ANALYSIS_KEY_MAX_TTL_HOURS", 24)
+	return time.Duration(hours * float64(time.Hour))
+}
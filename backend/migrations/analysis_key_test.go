@@ -0,0 +1,211 @@
+package migrations
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Fixed key and IV of the vectors below, made with
+// openssl enc -aes-256-cbc -K <key> -iv <iv> -base64
+const (
+	testEntryKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testEntryIV  = "0f0e0d0c0b0a09080706050403020100"
+)
+
+func testKey(t *testing.T, value string) []byte {
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEntryContentRoundTrip(t *testing.T) {
+	key := testKey(t, testEntryKey)
+
+	scenarios := []string{
+		"",
+		"short",
+		"exactly 16 bytes",
+		"Emoji 🌱 and accents: café, naïve",
+		strings.Repeat("a long entry ", 100),
+	}
+
+	for _, content := range scenarios {
+		encrypted, err := encryptEntryContent(content, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iv, _, ok := strings.Cut(encrypted, ":")
+		if !ok || len(iv) != 32 {
+			t.Fatalf("Expected a hex IV followed by ':', got %q", encrypted)
+		}
+
+		decrypted, err := decryptEntryContent(encrypted, key)
+		if err != nil {
+			t.Fatalf("Failed to decrypt %q: %v", content, err)
+		}
+		if decrypted != content {
+			t.Errorf("Expected %q, got %q", content, decrypted)
+		}
+	}
+}
+
+func TestDecryptEntryContent(t *testing.T) {
+	key := testKey(t, testEntryKey)
+	otherKey := testKey(t, strings.Repeat("ff", 32))
+
+	scenarios := []struct {
+		name      string
+		encrypted string
+		key       []byte
+		expected  string
+		expectErr bool
+	}{
+		{
+			name:      "fixed vector",
+			encrypted: testEntryIV + ":a0Nskv/iph/+JSSbu1HU3bTSUp4PT24bih+9ZKZfChCp0tCsRrHjwwwgTX1XQbhk",
+			key:       key,
+			expected:  "Today I wrote about a long walk by the river.",
+		},
+		{
+			// Last byte 0x05, but the bytes before it aren't
+			name:      "bad padding",
+			encrypted: testEntryIV + ":E4/bSrS76OhfGP09Nkl6Kw==",
+			key:       key,
+			expectErr: true,
+		},
+		{
+			name:      "zero padding",
+			encrypted: testEntryIV + ":xstcg99xVlnlnDIU9lmXNg==",
+			key:       key,
+			expectErr: true,
+		},
+		{
+			name:      "wrong key",
+			encrypted: testEntryIV + ":a0Nskv/iph/+JSSbu1HU3bTSUp4PT24bih+9ZKZfChCp0tCsRrHjwwwgTX1XQbhk",
+			key:       otherKey,
+			expectErr: true,
+		},
+		{
+			name:      "missing separator",
+			encrypted: "a0Nskv/iph/+JSSbu1HU3bTSUp4PT24bih+9ZKZfChCp0tCsRrHjwwwgTX1XQbhk",
+			key:       key,
+			expectErr: true,
+		},
+		{
+			name:      "short IV",
+			encrypted: "0f0e:a0Nskv/iph/+JSSbu1HU3bTSUp4PT24bih+9ZKZfChCp0tCsRrHjwwwgTX1XQbhk",
+			key:       key,
+			expectErr: true,
+		},
+		{
+			name:      "ciphertext not a multiple of the block size",
+			encrypted: testEntryIV + ":" + base64.StdEncoding.EncodeToString([]byte("not a block")),
+			key:       key,
+			expectErr: true,
+		},
+		{
+			name:      "empty ciphertext",
+			encrypted: testEntryIV + ":",
+			key:       key,
+			expectErr: true,
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			result, err := decryptEntryContent(s.encrypted, s.key)
+			if s.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %q", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != s.expected {
+				t.Errorf("Expected %q, got %q", s.expected, result)
+			}
+		})
+	}
+}
+
+func TestUnwrapAnalysisKey(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyFile := filepath.Join(t.TempDir(), "analysis_key.pem")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AI_ANALYSIS_KEY_FILE", keyFile)
+
+	// The server key is loaded once per process, load the test key instead
+	resetAnalysisKey := func() {
+		analysisKeyOnce = sync.Once{}
+		analysisPrivateKey, analysisKeyErr = nil, nil
+	}
+	resetAnalysisKey()
+	defer resetAnalysisKey()
+
+	wrap := func(key []byte) string {
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &privateKey.PublicKey, key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(wrapped)
+	}
+
+	app := core.NewBaseApp(core.BaseAppConfig{DataDir: t.TempDir()})
+	key := testKey(t, testEntryKey)
+
+	scenarios := []struct {
+		name      string
+		wrapped   string
+		expectErr bool
+	}{
+		{"valid key", wrap(key), false},
+		{"key of the wrong size", wrap(key[:16]), true},
+		{"not base64", "not base64!", true},
+		{"not wrapped with the server key", base64.StdEncoding.EncodeToString(make([]byte, 256)), true},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			result, err := unwrapAnalysisKey(app, s.wrapped)
+			if s.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %x", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hex.EncodeToString(result) != testEntryKey {
+				t.Errorf("Expected key %s, got %x", testEntryKey, result)
+			}
+		})
+	}
+}
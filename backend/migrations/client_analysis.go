@@ -0,0 +1,299 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// markJobAwaitingClient hands a job over to the client, which decrypts the
+// entry locally and submits it through /api/ai/client-jobs
+func markJobAwaitingClient(app core.App, job *core.Record) error {
+	if cancelled, err := cancelIfSuperseded(app, job); err != nil || cancelled {
+		return err
+	}
+
+	job.Set("status", "awaiting_client")
+	job.Set("client_analyzed_at", "")
+	renderedPrompt{}.set(job)
+	clearJobLease(job)
+	if err := app.Save(job); err != nil {
+		return err
+	}
+	log.Printf("📨 Job %s is awaiting client-delegated analysis", job.Id)
+	return nil
+}
+
+// findClientJob loads an awaiting_client job owned by the authenticated user
+func findClientJob(e *core.RequestEvent) (*core.Record, error) {
+	job, err := e.App.FindRecordById("ai_processing_queue", e.Request.PathValue("id"))
+	if err != nil || job.GetString("user") != e.Auth.Id {
+		return nil, e.NotFoundError("Job not found.", err)
+	}
+
+	if job.GetString("status") != "awaiting_client" {
+		return nil, e.BadRequestError("Job is not awaiting client analysis.", nil)
+	}
+
+	return job, nil
+}
+
+// claimClientAnalysis marks that /analyze ran for an awaiting_client job. It
+// reports false when it already ran since the job was handed to the client.
+func claimClientAnalysis(app core.App, jobID string) (bool, error) {
+	result, err := app.DB().NewQuery(`
+		UPDATE ai_processing_queue
+		SET client_analyzed_at = {:now}
+		WHERE id = {:id} AND status = 'awaiting_client'
+			AND (client_analyzed_at IS NULL OR client_analyzed_at = '')
+	`).Bind(dbx.Params{
+		"id":  jobID,
+		"now": time.Now().UTC().Format(types.DefaultDateLayout),
+	}).Execute()
+	if err != nil {
+		return false, err
+	}
+
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// releaseClientAnalysis allows /analyze to run again after the provider call failed
+func releaseClientAnalysis(app core.App, jobID string) {
+	_, err := app.DB().NewQuery(`
+		UPDATE ai_processing_queue SET client_analyzed_at = '' WHERE id = {:id}
+	`).Bind(dbx.Params{"id": jobID}).Execute()
+	if err != nil {
+		log.Printf("Warning: Failed to release client analysis of job %s: %v", jobID, err)
+	}
+}
+
+// contentMatchesHash reports whether content is the plaintext an entry's
+// content_hash was computed from (SHA-256 hex, see hashContent in encryption.ts)
+func contentMatchesHash(content, contentHash string) bool {
+	sum := sha256.Sum256([]byte(content))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(contentHash))) == 1
+}
+
+// RegisterClientAnalysisRoutes registers the endpoints used by zero-knowledge
+// users to run AI analysis on content they decrypted locally. The plaintext
+// only lives in memory for the duration of the request.
+func RegisterClientAnalysisRoutes(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		group := se.Router.Group("/api/ai/client-jobs")
+		group.Bind(apis.RequireAuth("users"))
+
+		// List the caller's jobs waiting for client-delegated analysis
+		group.GET("", func(e *core.RequestEvent) error {
+			jobs, err := e.App.FindRecordsByFilter(
+				"ai_processing_queue",
+				"user = {:userId} && status = 'awaiting_client'",
+				"scheduled_at",
+				50,
+				0,
+				map[string]any{"userId": e.Auth.Id},
+			)
+			if err != nil {
+				return e.InternalServerError("Failed to load jobs.", err)
+			}
+
+			items := make([]map[string]any, 0, len(jobs))
+			for _, job := range jobs {
+				items = append(items, map[string]any{
+					"id":           job.Id,
+					"job_type":     job.GetString("job_type"),
+					"entry_id":     job.GetString("entry_id"),
+					"scheduled_at": job.GetString("scheduled_at"),
+				})
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{"items": items})
+		})
+
+		// Forward locally decrypted content to the model and return the result.
+		// The content must be the entry's (checked against its content_hash)
+		// and each hand-over is analyzed once. The content is not persisted.
+		group.POST("/{id}/analyze", func(e *core.RequestEvent) error {
+			job, err := findClientJob(e)
+			if err != nil {
+				return err
+			}
+
+			if job.GetString("job_type") != "entry_analysis" {
+				return e.BadRequestError("Only entry analysis can be delegated to the client.", nil)
+			}
+
+			body := struct {
+				Content string `json:"content"`
+			}{}
+			if err := e.BindBody(&body); err != nil {
+				return e.BadRequestError("Invalid request body.", err)
+			}
+			if strings.TrimSpace(body.Content) == "" {
+				return e.BadRequestError("Content is required.", nil)
+			}
+
+			if aiProvider == nil || aiTokenBucket == nil {
+				return e.Error(http.StatusServiceUnavailable, "AI queue processor is not running.", nil)
+			}
+
+			entry, err := e.App.FindRecordById("journal_entries", job.GetString("entry_id"))
+			if err != nil {
+				return e.NotFoundError("Entry not found.", err)
+			}
+			if hash := entry.GetString("content_hash"); hash != "" && !contentMatchesHash(body.Content, hash) {
+				return e.BadRequestError("Content does not match the entry.", nil)
+			}
+
+			user, err := e.App.FindRecordById("users", e.Auth.Id)
+			if err != nil {
+				return e.NotFoundError("User not found.", err)
+			}
+			if !loadUserQuota(user, time.Now()).allows(int(jobTokenEstimate(job))) {
+				return e.TooManyRequestsError("Your AI usage quota is used up, try again after it resets.", nil)
+			}
+
+			if !aiTokenBucket.Consume(jobTokenEstimate(job)) {
+				return e.TooManyRequestsError("AI rate limit reached, try again shortly.", nil)
+			}
+
+			claimed, err := claimClientAnalysis(e.App, job.Id)
+			if err != nil {
+				aiTokenBucket.Adjust(jobTokenEstimate(job))
+				return e.InternalServerError("Failed to start the analysis.", err)
+			}
+			if !claimed {
+				aiTokenBucket.Adjust(jobTokenEstimate(job))
+				return e.BadRequestError("This job was already analyzed.", nil)
+			}
+
+			prompt, rendered, err := renderPrompt(e.App, "entry_analysis", e.Auth.Id, entryPromptData{Content: body.Content})
+			if err != nil {
+				aiTokenBucket.Adjust(jobTokenEstimate(job))
+				releaseClientAnalysis(e.App, job.Id)
+				return e.InternalServerError("Failed to build the analysis prompt.", err)
+			}
+
+			ctx, cancel := context.WithTimeout(e.Request.Context(), aiRequestTimeout)
+			defer cancel()
+
+			resp, err := aiProvider.Generate(ctx, AIRequest{
+				Prompt:          prompt,
+				Temperature:     0.4,
+				MaxOutputTokens: entryAnalysisMaxTokens,
+			})
+			if err != nil {
+				aiTokenBucket.Adjust(jobTokenEstimate(job))
+				releaseClientAnalysis(e.App, job.Id)
+				return e.Error(http.StatusBadGateway, "AI analysis failed.", err)
+			}
+
+			// Correct the bucket and keep the usage and the prompt on the job
+			job.Set("client_analyzed_at", time.Now().UTC())
+			rendered.set(job)
+			job.Set("prompt_tokens", 0)
+			job.Set("completion_tokens", 0)
+			recordJobUsage(job, resp)
+			settleJobTokens(e.App, job)
+			if err := e.App.Save(job); err != nil {
+				log.Printf("Warning: Failed to store token usage for job %s: %v", job.Id, err)
+			}
+
+			// The tokens were used, but the hand-over may be analyzed again
+			result, err := parseEntryAnalysis(resp.Text)
+			if err != nil {
+				releaseClientAnalysis(e.App, job.Id)
+				return e.Error(http.StatusBadGateway, "AI returned an invalid analysis.", err)
+			}
+
+			return e.JSON(http.StatusOK, result)
+		})
+
+		// Store the client-encrypted insight and complete the job
+		group.POST("/{id}/complete", func(e *core.RequestEvent) error {
+			job, err := findClientJob(e)
+			if err != nil {
+				return err
+			}
+
+			body := struct {
+				EncryptedInsights string   `json:"encrypted_insights"`
+				GrowthScore       *float64 `json:"growth_score"`
+				EmotionalTrend    string   `json:"emotional_trend"`
+			}{}
+			if err := e.BindBody(&body); err != nil {
+				return e.BadRequestError("Invalid request body.", err)
+			}
+			if !strings.Contains(body.EncryptedInsights, ":") {
+				return e.BadRequestError("encrypted_insights must be client-encrypted (IV:EncryptedData).", nil)
+			}
+
+			entry, err := e.App.FindRecordById("journal_entries", job.GetString("entry_id"))
+			if err != nil {
+				return e.NotFoundError("Entry not found.", err)
+			}
+
+			entryDate, ok := EntryLocalDate(e.App, entry)
+			if !ok {
+				return e.BadRequestError("Entry has no date.", nil)
+			}
+
+			analysis, err := entryAnalysisRecord(e.App, entry)
+			if err != nil {
+				return e.InternalServerError("Failed to store analysis.", err)
+			}
+
+			// Fields the client doesn't send are cleared, they described the
+			// previous version of the entry
+			analysis.Set("period_start", entryDate)
+			analysis.Set("period_end", entryDate)
+			analysis.Set("encrypted_insights", body.EncryptedInsights)
+			analysis.Set("related_entries", []string{entry.Id})
+			analysis.Set("prompt_version", job.GetString("prompt_version"))
+			analysis.Set("prompt_hash", job.GetString("prompt_hash"))
+			analysis.Set("growth_score", nil)
+			analysis.Set("emotional_trend", "")
+			analysis.Set("key_themes", nil)
+			analysis.Set("action_items", nil)
+			if body.GrowthScore != nil {
+				analysis.Set("growth_score", clampScore(*body.GrowthScore))
+			}
+			if body.EmotionalTrend != "" {
+				analysis.Set("emotional_trend", normalizeEmotionalTrend(body.EmotionalTrend, ""))
+			}
+
+			err = e.App.RunInTransaction(func(txApp core.App) error {
+				if err := txApp.Save(analysis); err != nil {
+					return err
+				}
+
+				entry.Set("ai_processed", true)
+				if err := txApp.UnsafeWithoutHooks().Save(entry); err != nil {
+					return err
+				}
+
+				job.Set("status", "completed")
+				job.Set("completed_at", time.Now().UTC().Format(time.RFC3339))
+				return txApp.Save(job)
+			})
+			if err != nil {
+				return e.InternalServerError("Failed to store analysis.", err)
+			}
+
+			log.Printf("✅ Job %s completed by client-delegated analysis", job.Id)
+			return e.JSON(http.StatusOK, map[string]any{"id": analysis.Id})
+		})
+
+		return se.Next()
+	})
+}
@@ -0,0 +1,110 @@
+package migrations
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+// stubProvider answers every prompt with a fixed text
+type stubProvider struct {
+	text string
+}
+
+func (p *stubProvider) Name() string {
+	return "stub"
+}
+
+func (p *stubProvider) Generate(ctx context.Context, req AIRequest) (*AIResponse, error) {
+	return &AIResponse{Text: p.text, PromptTokens: 100, CompletionTokens: 50}, nil
+}
+
+func TestClientAnalyze(t *testing.T) {
+	defer func(provider AIProvider, bucket *TokenBucket) {
+		aiProvider, aiTokenBucket = provider, bucket
+	}(aiProvider, aiTokenBucket)
+
+	scenarios := []struct {
+		name            string
+		response        string
+		expectedStatus  int
+		expectedContent []string
+		claimKept       bool
+	}{
+		{
+			name:            "valid analysis",
+			response:        `{"growth_score": 7, "emotional_trend": "improving", "themes": ["rest"]}`,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"growth_score":7`},
+			claimKept:       true,
+		},
+		{
+			name:            "invalid analysis releases the claim",
+			response:        "Sorry, I can't help with that.",
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{"AI returned an invalid analysis."},
+			claimKept:       false,
+		},
+	}
+
+	for _, s := range scenarios {
+		var jobID string
+
+		scenario := &tests.ApiScenario{
+			Name:            s.name,
+			Method:          http.MethodPost,
+			Body:            strings.NewReader(`{"content": "Today I wrote about a long walk by the river."}`),
+			ExpectedStatus:  s.expectedStatus,
+			ExpectedContent: s.expectedContent,
+		}
+
+		scenario.TestAppFactory = func(t testing.TB) *tests.TestApp {
+			app, err := tests.NewTestApp(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			RegisterClientAnalysisRoutes(app)
+
+			aiProvider = &stubProvider{text: s.response}
+			aiTokenBucket = NewTokenBucket(100000, 1000)
+
+			user, entry := createTestEntry(t, app, "client@example.com")
+			enqueueEntryAnalysis(t, app, user.Id, entry.Id)
+
+			job, err := app.FindFirstRecordByData("ai_processing_queue", "entry_id", entry.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := markJobAwaitingClient(app, job); err != nil {
+				t.Fatal(err)
+			}
+			jobID = job.Id
+
+			token, err := user.NewAuthToken()
+			if err != nil {
+				t.Fatal(err)
+			}
+			scenario.URL = "/api/ai/client-jobs/" + jobID + "/analyze"
+			scenario.Headers = map[string]string{"Authorization": token}
+			return app
+		}
+
+		scenario.AfterTestFunc = func(t testing.TB, app *tests.TestApp, res *http.Response) {
+			job, err := app.FindRecordById("ai_processing_queue", jobID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claimed := !job.GetDateTime("client_analyzed_at").IsZero(); claimed != s.claimKept {
+				t.Errorf("Expected the claim kept %v, got %v", s.claimKept, claimed)
+			}
+			if job.GetInt("prompt_tokens") != 100 || job.GetInt("completion_tokens") != 50 {
+				t.Errorf("Expected the token usage stored on the job, got %d/%d", job.GetInt("prompt_tokens"), job.GetInt("completion_tokens"))
+			}
+		}
+
+		scenario.Test(t)
+	}
+}